@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 
 	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
@@ -13,52 +12,45 @@ import (
 )
 
 func main() {
+	// Stop waiting for authorization on Ctrl-C.
+	ctx, stop := oauth2dev.SignalContext()
+	err := run(ctx)
+	stop()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context) error {
 	var (
 		clientID    = os.Getenv("OIDCCLIENTID")
 		providerURL = os.Getenv("OIDCPROVIDERURL")
 	)
-	ctx := context.Background()
 
 	provider, err := oidc.NewProvider(ctx, providerURL)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	// The usual OAuth2 configuration
-	var clientOAuthConfig = &oauth2.Config{
-		ClientID: clientID,
-		Endpoint: provider.Endpoint(),
-
-		// for example...
-		Scopes: []string{oidc.ScopeOpenID, "profile", "email"},
+		return err
 	}
-
-	// Augment OAuth2 configuration with device endpoints.
-	var clientDeviceOAuthConfig = &oauth2dev.Config{
-		Config: clientOAuthConfig,
-		DeviceEndpoint: oauth2dev.DeviceEndpoint{
-			CodeURL: provider.Endpoint().AuthURL + "/device",
+	config := &oauth2dev.Config{
+		Config: &oauth2.Config{
+			ClientID: clientID,
+			// for example...
+			Scopes: []string{oidc.ScopeOpenID, "profile", "email"},
 		},
 	}
 
-	// Use default HTTP client.
-	client := http.DefaultClient
-
-	// Get URL and code for user.
-	dcr, err := oauth2dev.RequestDeviceCode(client, clientDeviceOAuthConfig)
-	if err != nil {
-		log.Fatal(err)
-	}
-	fmt.Printf("Visit: %v\n", dcr.VerificationURLComplete)
-
-	// Wait for a token. It will be a standard oauth2.Token.
-	accessToken, err := oauth2dev.WaitForDeviceAuthorization(client,
-		clientDeviceOAuthConfig, dcr)
+	// Show the URL and code to the user, then wait for a token. It will be a
+	// standard oauth2.Token, along with the verified ID token.
+	result, err := oauth2dev.RunDeviceFlow(ctx, provider, config, func(dcr *oauth2dev.DeviceCode) {
+		fmt.Println(dcr.UserInstructions())
+	})
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	fmt.Printf("Access token: %v\n", accessToken)
+	fmt.Printf("Access token: %v\n", result.Token)
+	fmt.Printf("Subject: %v\n", result.IDToken.Subject)
 
 	// Now use the token as usual...
+	return nil
 }
@@ -1,8 +1,21 @@
 module github.com/ahanafy/oidcgo
 
-go 1.16
+go 1.21
 
 require (
+	github.com/boombuler/barcode v1.1.0
 	github.com/coreos/go-oidc/v3 v3.0.0
+	github.com/makiuchi-d/gozxing v0.1.1
 	golang.org/x/oauth2 v0.0.0-20210628180205-a41e5a781914
 )
+
+require (
+	github.com/golang/protobuf v1.4.2 // indirect
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9 // indirect
+	golang.org/x/net v0.0.0-20200822124328-c89045814202 // indirect
+	golang.org/x/text v0.3.7 // indirect
+	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+	google.golang.org/appengine v1.6.6 // indirect
+	google.golang.org/protobuf v1.25.0 // indirect
+	gopkg.in/square/go-jose.v2 v2.5.1 // indirect
+)
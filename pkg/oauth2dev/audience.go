@@ -0,0 +1,53 @@
+package oauth2dev
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+var (
+	// ErrAudienceMismatch is an error returned, wrapped, when a JWT access
+	// token is not issued for the expected audience.
+	ErrAudienceMismatch = errors.New("access token audience mismatch")
+)
+
+// CheckAccessTokenAudience checks that, if token's access token is a JWT, its
+// aud claim contains audience. Opaque access tokens are not checked and give
+// no error. The JWT's signature is not verified: this guards against a
+// misconfigured provider issuing tokens for the wrong resource, not against
+// forgery, which is for the resource server to detect.
+func CheckAccessTokenAudience(token *oauth2.Token, audience string) error {
+	parts := strings.Split(token.AccessToken, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil
+	}
+	var claims struct {
+		Audience json.RawMessage `json:"aud"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil
+	}
+
+	var auds []string
+	if err := json.Unmarshal(claims.Audience, &auds); err != nil {
+		var aud string
+		if err := json.Unmarshal(claims.Audience, &aud); err == nil {
+			auds = []string{aud}
+		}
+	}
+	for _, aud := range auds {
+		if aud == audience {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: expected %q, got %q", ErrAudienceMismatch, audience, auds)
+}
@@ -0,0 +1,59 @@
+package oauth2dev_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+	"golang.org/x/oauth2"
+)
+
+// unsignedJWT returns a JWT with the given JSON claims and a dummy signature.
+func unsignedJWT(claims string) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(`{"alg":"RS256"}`)) + "." + enc.EncodeToString([]byte(claims)) + ".c2ln"
+}
+
+func TestCheckAccessTokenAudience(t *testing.T) {
+	tests := []struct {
+		name   string
+		access string
+		ok     bool
+	}{
+		{"string aud", unsignedJWT(`{"aud":"api"}`), true},
+		{"array aud", unsignedJWT(`{"aud":["other","api"]}`), true},
+		{"padded payload", "e30." + base64.URLEncoding.EncodeToString([]byte(`{"aud":"api"}`)) + ".c2ln", true},
+		{"other aud", unsignedJWT(`{"aud":"other"}`), false},
+		{"other auds", unsignedJWT(`{"aud":["a","b"]}`), false},
+		{"no aud", unsignedJWT(`{"sub":"user"}`), false},
+		{"opaque", "2YotnFZFEjr1zCsicMWpAA", true},
+		{"not JSON", "a.bm90IGpzb24.c", true},
+		{"not base64", "a.!!!.c", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := oauth2dev.CheckAccessTokenAudience(&oauth2.Token{AccessToken: tt.access}, "api")
+			if tt.ok && err != nil {
+				t.Errorf("CheckAccessTokenAudience = %v, want nil", err)
+			} else if !tt.ok && !errors.Is(err, oauth2dev.ErrAudienceMismatch) {
+				t.Errorf("CheckAccessTokenAudience = %v, want ErrAudienceMismatch", err)
+			}
+		})
+	}
+}
+
+func TestWaitAccessTokenAudience(t *testing.T) {
+	body := `{"access_token":"` + unsignedJWT(`{"aud":"other"}`) + `","token_type":"Bearer"}`
+	s := newTestServer(t, response{http.StatusOK, body, nil})
+	s.config.AccessTokenAudience = "api"
+
+	if _, err := s.wait(); !errors.Is(err, oauth2dev.ErrAudienceMismatch) {
+		t.Errorf("WaitForDeviceAuthorization error = %v, want ErrAudienceMismatch", err)
+	}
+	s.config.AccessTokenAudience = "other"
+	if _, err := s.wait(); err != nil {
+		t.Errorf("WaitForDeviceAuthorization with the right audience: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package oauth2dev
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/oauth2"
+)
+
+// Authorize runs the whole device flow. It requests a device code, calls
+// prompt so that the caller can present the verification URL and user code to
+// the user however it sees fit, then waits for the user to authorize the app
+// and returns the new token. prompt may be nil.
+//
+// Every way in which the flow can end without a token maps to an error which
+// can be tested with errors.Is:
+//
+//   - ErrAccessDenied if the user denied access;
+//   - ErrDeviceCodeExpired if the device code expired, whether the provider
+//     said so or its lifetime passed while polling;
+//   - ErrMaxPollAttempts if Config.MaxPollAttempts polls were made without
+//     the user deciding;
+//   - context.Canceled or context.DeadlineExceeded if ctx was cancelled or
+//     its deadline passed, at whatever stage of the flow.
+//
+// Any other error means the provider or the network failed.
+func Authorize(ctx context.Context, client *http.Client, config *Config, prompt func(*DeviceCode)) (*oauth2.Token, error) {
+	return (&Client{HTTPClient: client, Config: config}).Authorize(ctx, prompt)
+}
+
+// Authorize runs the whole device flow, as the package-level Authorize.
+func (c *Client) Authorize(ctx context.Context, prompt func(*DeviceCode)) (*oauth2.Token, error) {
+	code, err := c.RequestDeviceCode(ctx)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	if prompt != nil {
+		prompt(code)
+	}
+
+	tok, err := c.WaitForAuthorization(ctx, code)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return tok, nil
+}
+
+// contextError returns err, wrapping ctx's error along with it if ctx is done
+// and err does not already say so, for example because the request failed
+// while its body was being read. Both errors stay reachable with errors.Is and
+// errors.As.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+		return fmt.Errorf("%w: %w", ctxErr, err)
+	}
+	return err
+}
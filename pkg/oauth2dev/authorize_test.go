@@ -0,0 +1,128 @@
+package oauth2dev_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev/oauth2devtest"
+)
+
+func TestAuthorize(t *testing.T) {
+	s, config, _ := newFixture(t, oauth2devtest.Options{Pending: 2, AccessToken: "access"})
+
+	var prompted *oauth2dev.DeviceCode
+	tok, err := oauth2dev.Authorize(context.Background(), s.Client(), config, func(code *oauth2dev.DeviceCode) {
+		prompted = code
+		if s.Polls() != 0 {
+			t.Error("prompt called after polling began")
+		}
+	})
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if tok.AccessToken != "access" {
+		t.Errorf("token = %+v", tok)
+	}
+	if prompted == nil || prompted.UserCode != "WDJB-MJHT" {
+		t.Errorf("prompt called with %+v", prompted)
+	}
+
+	if _, err := oauth2dev.NewClient(config, oauth2dev.WithHTTPClient(s.Client())).Authorize(context.Background(), nil); err != nil {
+		t.Errorf("Client.Authorize with a nil prompt: %v", err)
+	}
+}
+
+func TestAuthorizeErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []response
+		configure func(*oauth2dev.Config)
+		want      error
+	}{{
+		name:      "denied",
+		responses: []response{pending, {http.StatusBadRequest, `{"error":"access_denied"}`, nil}},
+		want:      oauth2dev.ErrAccessDenied,
+	}, {
+		name:      "expired_token",
+		responses: []response{pending, {http.StatusBadRequest, `{"error":"expired_token"}`, nil}},
+		want:      oauth2dev.ErrDeviceCodeExpired,
+	}, {
+		name:      "lifetime passed",
+		responses: []response{pending},
+		want:      oauth2dev.ErrDeviceCodeExpired,
+	}, {
+		name:      "too many polls",
+		responses: []response{pending},
+		configure: func(c *oauth2dev.Config) { c.MaxPollAttempts = 2 },
+		want:      oauth2dev.ErrMaxPollAttempts,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.responses...)
+			if tt.configure != nil {
+				tt.configure(s.config)
+			}
+			if _, err := oauth2dev.Authorize(context.Background(), s.Client(), s.config, nil); !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeCancelled(t *testing.T) {
+	// Cancelled while requesting the device code.
+	s := newTestServer(t, token)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := oauth2dev.Authorize(ctx, s.Client(), s.config, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("error when cancelled before the device request = %v, want context.Canceled", err)
+	}
+
+	// Cancelled by the prompt, before polling.
+	ctx, cancel = context.WithCancel(context.Background())
+	if _, err := oauth2dev.Authorize(ctx, s.Client(), s.config, func(*oauth2dev.DeviceCode) { cancel() }); !errors.Is(err, context.Canceled) {
+		t.Errorf("error when cancelled by the prompt = %v, want context.Canceled", err)
+	}
+
+	// The deadline passes during a poll whose error does not mention it.
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	blocking := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Path == "/token" {
+			<-r.Context().Done()
+			return nil, errors.New("connection reset")
+		}
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	s.config.MaxTransportRetries = -1
+	if _, err := oauth2dev.Authorize(ctx, blocking, s.config, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error when the deadline passes during a poll = %v, want context.DeadlineExceeded", err)
+	}
+
+	// The deadline passes during a poll which then fails with an HTTP error;
+	// the error stays reachable along with the deadline.
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	badGateway := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Path == "/token" {
+			<-r.Context().Done()
+			return &http.Response{
+				StatusCode: http.StatusBadGateway,
+				Body:       io.NopCloser(strings.NewReader("oops")),
+				Request:    r,
+			}, nil
+		}
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	_, err := oauth2dev.Authorize(ctx, badGateway, s.config, nil)
+	var httpErr *oauth2dev.HTTPError
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("error when a poll fails after the deadline = %v, want context.DeadlineExceeded and a 502 *HTTPError", err)
+	}
+}
@@ -0,0 +1,44 @@
+package oauth2dev
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"golang.org/x/oauth2"
+)
+
+// openBrowser opens url in the user's default browser. It is a variable so
+// that tests can replace it.
+var openBrowser = func(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
+
+// AuthorizeWithBrowser is like Authorize but tries to open the verification URL
+// in the user's default browser. Whether or not that succeeds, it prints the
+// device code's UserInstructions to standard error as a fallback.
+func AuthorizeWithBrowser(ctx context.Context, client *http.Client, config *Config) (*oauth2.Token, error) {
+	return Authorize(ctx, client, config, func(code *DeviceCode) {
+		u := code.VerificationURLComplete
+		if u == "" {
+			u = code.VerificationURL
+		}
+
+		if err := openBrowser(u); err == nil {
+			fmt.Fprintf(os.Stderr, "Your browser has been opened to %v\n", u)
+		}
+		fmt.Fprintln(os.Stderr, code.UserInstructions())
+	})
+}
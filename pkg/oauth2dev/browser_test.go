@@ -0,0 +1,64 @@
+package oauth2dev_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev/oauth2devtest"
+)
+
+// captureStderr returns what f writes to os.Stderr.
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = saved }()
+
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	f()
+	w.Close()
+	return <-out
+}
+
+func TestAuthorizeWithBrowser(t *testing.T) {
+	for _, openErr := range []error{nil, errors.New("no display")} {
+		s, config, _ := newFixture(t, oauth2devtest.Options{})
+		var opened []string
+		defer oauth2dev.SetOpenBrowser(func(url string) error {
+			opened = append(opened, url)
+			return openErr
+		})()
+
+		var err error
+		stderr := captureStderr(t, func() {
+			_, err = oauth2dev.AuthorizeWithBrowser(context.Background(), s.Client(), config)
+		})
+		if err != nil {
+			t.Fatalf("AuthorizeWithBrowser: %v", err)
+		}
+
+		complete := s.URL + "/verify?user_code=WDJB-MJHT"
+		if len(opened) != 1 || opened[0] != complete {
+			t.Errorf("opened %v, want %v", opened, complete)
+		}
+		if got := strings.Contains(stderr, "Your browser has been opened"); got != (openErr == nil) {
+			t.Errorf("browser error %v: stderr = %q", openErr, stderr)
+		}
+		if !strings.Contains(stderr, "check that it shows the code WDJB-MJHT") {
+			t.Errorf("stderr = %q, want the user instructions", stderr)
+		}
+	}
+}
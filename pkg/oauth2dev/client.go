@@ -0,0 +1,153 @@
+package oauth2dev
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// A Client runs the device flow for a Config, saving callers from passing the
+// HTTP client and Config to every call.
+type Client struct {
+	// HTTPClient is used to make requests. If nil, http.DefaultClient is
+	// used.
+	HTTPClient *http.Client
+
+	Config *Config
+}
+
+// DefaultTimeout is the timeout of the HTTP client built by NewClient when
+// none is supplied.
+const DefaultTimeout = 30 * time.Second
+
+// A ClientOption configures a Client built by NewClient.
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	httpClient *http.Client
+	transport  http.RoundTripper
+	timeout    time.Duration
+}
+
+// WithHTTPClient sets the HTTP client used to make requests.
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(o *clientOptions) {
+		o.httpClient = client
+	}
+}
+
+// WithTransport sets the transport used to make requests, for example to add
+// instrumentation, while keeping the other defaults of NewClient. Like
+// WithTimeout, it applies to a copy of any client given by WithHTTPClient.
+func WithTransport(rt http.RoundTripper) ClientOption {
+	return func(o *clientOptions) {
+		o.transport = rt
+	}
+}
+
+// WithTimeout sets the timeout of each HTTP request. It applies to the client
+// given by WithHTTPClient, which is copied rather than modified, or else to
+// the one built by NewClient, whose default is DefaultTimeout.
+func WithTimeout(d time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.timeout = d
+	}
+}
+
+// NewClient returns a Client for config. Unless WithHTTPClient is given, it
+// uses a new DefaultHTTPClient, whose timeout means that a provider which never
+// responds cannot hang the flow.
+func NewClient(config *Config, opts ...ClientOption) *Client {
+	var o clientOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var client *http.Client
+	if o.httpClient == nil {
+		client = DefaultHTTPClient()
+	} else if o.timeout != 0 || o.transport != nil {
+		c := *o.httpClient
+		client = &c
+	} else {
+		client = o.httpClient
+	}
+	if o.timeout != 0 {
+		client.Timeout = o.timeout
+	}
+	if o.transport != nil {
+		client.Transport = o.transport
+	}
+	return &Client{HTTPClient: client, Config: config}
+}
+
+// DefaultHTTPClient returns a new HTTP client suitable for the device flow,
+// and is the recommended client to pass to this package's functions. Unlike
+// http.DefaultClient it has a timeout, of DefaultTimeout. Its transport uses
+// the proxy given by the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+// variables, as http.ProxyFromEnvironment does.
+func DefaultHTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   10 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+		Timeout: DefaultTimeout,
+	}
+}
+
+// httpClient returns the HTTP client used by c.
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// RequestDeviceCode initiates the device flow, as RequestDeviceCodeContext.
+func (c *Client) RequestDeviceCode(ctx context.Context) (*DeviceCode, error) {
+	code, _, err := c.RequestDeviceCodeDetailed(ctx)
+	return code, err
+}
+
+// RequestDeviceCodeDetailed is like RequestDeviceCode but also returns the
+// headers of the provider's response, as the function
+// RequestDeviceCodeDetailed.
+func (c *Client) RequestDeviceCodeDetailed(ctx context.Context) (*DeviceCode, http.Header, error) {
+	start := c.Config.clock().Now()
+	code, header, err := requestDeviceCode(ctx, c.httpClient(), c.Config)
+	if err != nil {
+		c.Config.onError(err)
+		return nil, header, err
+	}
+	if c.Config.OnDeviceCodeRequested != nil {
+		c.Config.OnDeviceCodeRequested(code, c.Config.clock().Now().Sub(start))
+	}
+	return code, header, nil
+}
+
+// WaitForAuthorization polls for the user to authorize the app, as
+// WaitForDeviceAuthorizationContext.
+func (c *Client) WaitForAuthorization(ctx context.Context, code *DeviceCode) (*oauth2.Token, error) {
+	start := c.Config.clock().Now()
+	tok, err := waitForDeviceAuthorization(ctx, c.httpClient(), c.Config, code)
+	if err != nil {
+		c.Config.onError(err)
+		return nil, err
+	}
+	if c.Config.OnTokenReceived != nil {
+		c.Config.OnTokenReceived(tok, c.Config.clock().Now().Sub(start))
+	}
+	return tok, nil
+}
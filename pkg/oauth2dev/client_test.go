@@ -0,0 +1,161 @@
+package oauth2dev_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+	"golang.org/x/oauth2"
+)
+
+func TestNewClient(t *testing.T) {
+	config := &oauth2dev.Config{}
+	c := oauth2dev.NewClient(config)
+	if c.Config != config {
+		t.Error("Config not set")
+	}
+	if c.HTTPClient == nil || c.HTTPClient == http.DefaultClient || c.HTTPClient.Timeout != oauth2dev.DefaultTimeout {
+		t.Errorf("default HTTPClient = %+v, want a new client with DefaultTimeout", c.HTTPClient)
+	}
+	if tr, ok := c.HTTPClient.Transport.(*http.Transport); !ok || tr.Proxy == nil {
+		t.Errorf("default Transport = %#v, want an *http.Transport using a proxy", c.HTTPClient.Transport)
+	}
+	if oauth2dev.NewClient(config).HTTPClient == c.HTTPClient {
+		t.Error("NewClient clients share an HTTP client")
+	}
+}
+
+func TestDefaultHTTPClient(t *testing.T) {
+	c := oauth2dev.DefaultHTTPClient()
+	if c.Timeout != oauth2dev.DefaultTimeout {
+		t.Errorf("Timeout = %v, want DefaultTimeout", c.Timeout)
+	}
+	tr, ok := c.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("Transport = %T, want an *http.Transport", c.Transport)
+	}
+	// http.ProxyFromEnvironment reads the environment only once per process,
+	// so the function itself is compared.
+	if tr.Proxy == nil || reflect.ValueOf(tr.Proxy).Pointer() != reflect.ValueOf(http.ProxyFromEnvironment).Pointer() {
+		t.Error("Transport does not use http.ProxyFromEnvironment")
+	}
+	if oauth2dev.DefaultHTTPClient() == c {
+		t.Error("DefaultHTTPClient returned the same client twice")
+	}
+}
+
+func TestNewClientOptions(t *testing.T) {
+	hc := &http.Client{Timeout: time.Minute}
+	rt := roundTripFunc(func(*http.Request) (*http.Response, error) { return nil, errors.New("unused") })
+
+	if c := oauth2dev.NewClient(nil, oauth2dev.WithHTTPClient(hc)); c.HTTPClient != hc {
+		t.Errorf("WithHTTPClient: HTTPClient = %p, want %p", c.HTTPClient, hc)
+	}
+
+	c := oauth2dev.NewClient(nil, oauth2dev.WithHTTPClient(hc), oauth2dev.WithTimeout(time.Second))
+	if c.HTTPClient == hc || c.HTTPClient.Timeout != time.Second || hc.Timeout != time.Minute {
+		t.Errorf("WithTimeout: HTTPClient timeout = %v, original %v", c.HTTPClient.Timeout, hc.Timeout)
+	}
+
+	c = oauth2dev.NewClient(nil, oauth2dev.WithHTTPClient(hc), oauth2dev.WithTransport(rt))
+	if c.HTTPClient == hc || c.HTTPClient.Timeout != time.Minute || hc.Transport != nil {
+		t.Errorf("WithTransport: HTTPClient = %+v, original %+v", c.HTTPClient, hc)
+	}
+	if _, ok := c.HTTPClient.Transport.(roundTripFunc); !ok {
+		t.Errorf("WithTransport: Transport = %T", c.HTTPClient.Transport)
+	}
+
+	c = oauth2dev.NewClient(nil, oauth2dev.WithTransport(rt))
+	if c.HTTPClient.Timeout != oauth2dev.DefaultTimeout {
+		t.Errorf("WithTransport without a client: timeout = %v, want DefaultTimeout", c.HTTPClient.Timeout)
+	}
+}
+
+func TestClientWithTransport(t *testing.T) {
+	s := newTestServer(t, pending, token)
+	var paths []string
+	rt := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		paths = append(paths, r.URL.Path)
+		return http.DefaultTransport.RoundTrip(r)
+	})
+	c := oauth2dev.NewClient(s.config, oauth2dev.WithTransport(rt))
+
+	if _, err := c.Authorize(context.Background(), nil); err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if want := []string{"/device", "/token", "/token"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("requests through the transport = %v, want %v", paths, want)
+	}
+}
+
+func TestClientDefaultHTTPClient(t *testing.T) {
+	s := newTestServer(t, token)
+	c := &oauth2dev.Client{Config: s.config}
+
+	code, err := c.RequestDeviceCode(context.Background())
+	if err != nil {
+		t.Fatalf("RequestDeviceCode with a nil HTTPClient: %v", err)
+	}
+	if _, err := c.WaitForAuthorization(context.Background(), code); err != nil {
+		t.Errorf("WaitForAuthorization with a nil HTTPClient: %v", err)
+	}
+}
+
+func TestClientHooks(t *testing.T) {
+	s := newTestServer(t, token)
+	var requested *oauth2dev.DeviceCode
+	var errs []error
+	s.config.OnDeviceCodeRequested = func(code *oauth2dev.DeviceCode, elapsed time.Duration) { requested = code }
+	s.config.OnError = func(err error) { errs = append(errs, err) }
+	c := oauth2dev.NewClient(s.config, oauth2dev.WithHTTPClient(s.Client()))
+
+	code, err := c.RequestDeviceCode(context.Background())
+	if err != nil {
+		t.Fatalf("RequestDeviceCode: %v", err)
+	}
+	if requested != code {
+		t.Errorf("OnDeviceCodeRequested called with %v, want %v", requested, code)
+	}
+
+	s.config.DeviceEndpoint.CodeURL = s.URL + "/missing"
+	_, err = c.RequestDeviceCode(context.Background())
+	if err == nil || len(errs) != 1 || errs[0] != err {
+		t.Errorf("OnError called with %v for error %v", errs, err)
+	}
+}
+
+// BenchmarkRequestDeviceCode measures the allocations made by a device
+// authorization request, excluding the network.
+func BenchmarkRequestDeviceCode(b *testing.B) {
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		io.Copy(io.Discard, r.Body)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(testDeviceResponse)),
+			Request:    r,
+		}, nil
+	})}
+	config := &oauth2dev.Config{
+		Config: &oauth2.Config{
+			ClientID: "test-client",
+			Endpoint: oauth2.Endpoint{TokenURL: "https://example.com/token"},
+			Scopes:   []string{"openid", "profile", "email", "offline_access"},
+		},
+		DeviceEndpoint: oauth2dev.DeviceEndpoint{CodeURL: "https://example.com/device"},
+	}
+	c := oauth2dev.NewClient(config, oauth2dev.WithHTTPClient(client))
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.RequestDeviceCode(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
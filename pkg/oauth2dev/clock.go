@@ -0,0 +1,43 @@
+package oauth2dev
+
+import (
+	"context"
+	"time"
+)
+
+// A clock tells the time and waits. The device flow uses it rather than the
+// time package directly so that tests can check its timing deterministically.
+type clock interface {
+	Now() time.Time
+
+	// Sleep pauses for d or until ctx is done, whichever happens first. It
+	// returns ctx.Err() if ctx finished before d elapsed.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// realClock is a clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// clock returns the clock used by c.
+func (c *Config) clock() clock {
+	if c != nil && c.clk != nil {
+		return c.clk
+	}
+	return realClock{}
+}
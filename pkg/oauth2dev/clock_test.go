@@ -0,0 +1,43 @@
+package oauth2dev
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRealClockSleep(t *testing.T) {
+	var clk realClock
+	start := clk.Now()
+	if err := clk.Sleep(context.Background(), 10*time.Millisecond); err != nil {
+		t.Fatalf("Sleep: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Errorf("Sleep returned after %v, want at least 10ms", elapsed)
+	}
+}
+
+func TestRealClockSleepCancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := (realClock{}).Sleep(ctx, time.Minute); err != context.DeadlineExceeded {
+		t.Errorf("Sleep error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Sleep returned after %v, want about 10ms", elapsed)
+	}
+}
+
+func TestConfigClock(t *testing.T) {
+	if _, ok := (*Config)(nil).clock().(realClock); !ok {
+		t.Error("nil Config does not use the real clock")
+	}
+	fake := NewFakeClock()
+	c := &Config{}
+	SetClock(c, fake)
+	if c.clock() != fake {
+		t.Error("Config does not use its clk")
+	}
+}
@@ -0,0 +1,85 @@
+package oauth2dev
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	// ErrDeviceFlowUnsupported is an error returned when a provider's
+	// discovery document does not advertise a device authorization endpoint.
+	ErrDeviceFlowUnsupported = errors.New("provider does not support the device authorization flow")
+)
+
+// providerClaims holds the discovery document fields relevant to the device
+// flow which are not exposed by oidc.Provider.
+type providerClaims struct {
+	DeviceAuthorizationEndpoint string   `json:"device_authorization_endpoint"`
+	GrantTypesSupported         []string `json:"grant_types_supported"`
+}
+
+// supportsDeviceFlow reports whether the provider advertises the device flow.
+// Many providers omit grant_types_supported, so it is only checked if present.
+func (c *providerClaims) supportsDeviceFlow() bool {
+	if c.DeviceAuthorizationEndpoint == "" {
+		return false
+	}
+	if len(c.GrantTypesSupported) == 0 {
+		return true
+	}
+	for _, g := range c.GrantTypesSupported {
+		if g == deviceGrantType {
+			return true
+		}
+	}
+	return false
+}
+
+// DeviceEndpointFromProvider reads the device authorization endpoint from the
+// provider's discovery document. If the provider does not advertise one, or
+// lists grant_types_supported without the device grant, the error is
+// ErrDeviceFlowUnsupported.
+func DeviceEndpointFromProvider(provider *oidc.Provider) (DeviceEndpoint, error) {
+	var claims providerClaims
+	if err := provider.Claims(&claims); err != nil {
+		return DeviceEndpoint{}, fmt.Errorf("reading provider metadata: %w", err)
+	}
+	if !claims.supportsDeviceFlow() {
+		return DeviceEndpoint{}, ErrDeviceFlowUnsupported
+	}
+
+	return DeviceEndpoint{CodeURL: claims.DeviceAuthorizationEndpoint}, nil
+}
+
+// NewClientFromDiscovery fetches the discovery document of the OpenID Connect
+// provider at providerURL and returns a Client configured with its token and
+// device authorization endpoints. opts configure the Client as for NewClient,
+// and its HTTP client is also used to fetch the discovery document. If the
+// provider does not support the device flow, the error is
+// ErrDeviceFlowUnsupported.
+func NewClientFromDiscovery(ctx context.Context, providerURL, clientID, clientSecret string, scopes []string, opts ...ClientOption) (*Client, error) {
+	config := &Config{
+		Config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Scopes:       scopes,
+		},
+	}
+	c := NewClient(config, opts...)
+
+	provider, err := oidc.NewProvider(oidc.ClientContext(ctx, c.httpClient()), providerURL)
+	if err != nil {
+		return nil, err
+	}
+	deviceEndpoint, err := DeviceEndpointFromProvider(provider)
+	if err != nil {
+		return nil, err
+	}
+	config.Endpoint = provider.Endpoint()
+	config.DeviceEndpoint = deviceEndpoint
+	return c, nil
+}
@@ -0,0 +1,150 @@
+package oauth2dev_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// discoveryServer starts a server, closed when the test finishes, serving an
+// OpenID Connect discovery document with the given extra fields, and the
+// public half of testKey as its JWKS.
+func discoveryServer(t *testing.T, fields map[string]interface{}) *httptest.Server {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/keys" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(testJWKS(&testKey().PublicKey))
+			return
+		}
+		if r.URL.Path != "/.well-known/openid-configuration" {
+			http.NotFound(w, r)
+			return
+		}
+		doc := map[string]interface{}{
+			"issuer":                 srv.URL,
+			"authorization_endpoint": srv.URL + "/auth",
+			"token_endpoint":         srv.URL + "/token",
+			"jwks_uri":               srv.URL + "/keys",
+		}
+		for k, v := range fields {
+			doc[k] = v
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(doc)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDeviceEndpointFromProvider(t *testing.T) {
+	tests := map[string]struct {
+		fields map[string]interface{}
+		want   oauth2dev.DeviceEndpoint
+		err    error
+	}{
+		"advertised": {
+			fields: map[string]interface{}{"device_authorization_endpoint": "https://example.com/device"},
+			want:   oauth2dev.DeviceEndpoint{CodeURL: "https://example.com/device"},
+		},
+		"not advertised": {err: oauth2dev.ErrDeviceFlowUnsupported},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := discoveryServer(t, tt.fields)
+			provider, err := oidc.NewProvider(context.Background(), srv.URL)
+			if err != nil {
+				t.Fatalf("NewProvider: %v", err)
+			}
+
+			got, err := oauth2dev.DeviceEndpointFromProvider(provider)
+			if err != tt.err {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("DeviceEndpointFromProvider = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// countingTransport counts the requests made through it.
+type countingTransport struct {
+	n int32
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&c.n, 1)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestNewClientFromDiscovery(t *testing.T) {
+	srv := discoveryServer(t, map[string]interface{}{
+		"device_authorization_endpoint": "https://example.com/device",
+		"grant_types_supported":         []string{"authorization_code", "urn:ietf:params:oauth:grant-type:device_code"},
+	})
+
+	rt := &countingTransport{}
+
+	c, err := oauth2dev.NewClientFromDiscovery(context.Background(), srv.URL, "test-client", "test-secret",
+		[]string{"openid"}, oauth2dev.WithTransport(rt), oauth2dev.WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatalf("NewClientFromDiscovery: %v", err)
+	}
+	config := c.Config
+	if config.ClientID != "test-client" || config.ClientSecret != "test-secret" || !reflect.DeepEqual(config.Scopes, []string{"openid"}) {
+		t.Errorf("Config = %+v", config.Config)
+	}
+	if config.Endpoint.TokenURL != srv.URL+"/token" || config.Endpoint.AuthURL != srv.URL+"/auth" {
+		t.Errorf("Endpoint = %+v", config.Endpoint)
+	}
+	if config.DeviceEndpoint.CodeURL != "https://example.com/device" {
+		t.Errorf("DeviceEndpoint = %+v", config.DeviceEndpoint)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+
+	if c.HTTPClient == nil || c.HTTPClient.Timeout != 5*time.Second || c.HTTPClient.Transport != rt {
+		t.Errorf("HTTPClient = %+v, want the options applied", c.HTTPClient)
+	}
+	if atomic.LoadInt32(&rt.n) != 1 {
+		t.Errorf("%v requests made with the client's transport, want the discovery request", rt.n)
+	}
+}
+
+func TestNewClientFromDiscoveryUnsupported(t *testing.T) {
+	tests := map[string]map[string]interface{}{
+		"no device endpoint": nil,
+		"no device grant": {
+			"device_authorization_endpoint": "https://example.com/device",
+			"grant_types_supported":         []string{"authorization_code"},
+		},
+	}
+	for name, fields := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := discoveryServer(t, fields)
+			_, err := oauth2dev.NewClientFromDiscovery(context.Background(), srv.URL, "test-client", "", nil)
+			if !errors.Is(err, oauth2dev.ErrDeviceFlowUnsupported) {
+				t.Errorf("error = %v, want ErrDeviceFlowUnsupported", err)
+			}
+		})
+	}
+}
+
+func TestNewClientFromDiscoveryNoGrantTypes(t *testing.T) {
+	srv := discoveryServer(t, map[string]interface{}{"device_authorization_endpoint": "https://example.com/device"})
+
+	if _, err := oauth2dev.NewClientFromDiscovery(context.Background(), srv.URL, "test-client", "", nil); err != nil {
+		t.Errorf("NewClientFromDiscovery without grant_types_supported: %v", err)
+	}
+}
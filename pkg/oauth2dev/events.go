@@ -0,0 +1,79 @@
+package oauth2dev
+
+import (
+	"context"
+	"net/http"
+
+	"golang.org/x/oauth2"
+)
+
+// A PollState is the kind of a PollEvent.
+type PollState int
+
+const (
+	// PollPending means the user has not yet authorized the app.
+	PollPending PollState = iota
+	// PollSlowDown means the provider asked for polling to slow down.
+	PollSlowDown
+	// PollToken means the user authorized the app. It is a terminal state.
+	PollToken
+	// PollError means the flow failed. It is a terminal state.
+	PollError
+)
+
+// A PollEvent is a state transition sent by PollEvents.
+type PollEvent struct {
+	State PollState
+	// Progress is set for PollPending and PollSlowDown. Its Remaining field
+	// gives the time left for the user to authorize the app.
+	Progress Progress
+	// Token is set for PollToken.
+	Token *oauth2.Token
+	// Err is set for PollError.
+	Err error
+}
+
+// PollEvents is like WaitForDeviceAuthorizationContext, but instead of only
+// returning the final result it sends an event after every poll of the token
+// URL. The channel is closed after a PollToken or PollError event. If ctx is
+// done, events which have not been received are dropped and the channel is
+// closed once polling stops; the caller must keep receiving until then or
+// cancel ctx.
+func PollEvents(ctx context.Context, client *http.Client, config *Config, code *DeviceCode) <-chan PollEvent {
+	events := make(chan PollEvent)
+	send := func(ev PollEvent) {
+		select {
+		case events <- ev:
+		case <-ctx.Done():
+		}
+	}
+
+	// Poll with a copy of config so that the caller's OnProgress is kept.
+	var cfg *Config
+	if config != nil {
+		c := *config
+		onProgress := c.OnProgress
+		c.OnProgress = func(p Progress) {
+			if onProgress != nil {
+				onProgress(p)
+			}
+			state := PollPending
+			if p.SlowDown {
+				state = PollSlowDown
+			}
+			send(PollEvent{State: state, Progress: p})
+		}
+		cfg = &c
+	}
+
+	go func() {
+		defer close(events)
+		token, err := WaitForDeviceAuthorizationContext(ctx, client, cfg, code)
+		if err != nil {
+			send(PollEvent{State: PollError, Err: err})
+			return
+		}
+		send(PollEvent{State: PollToken, Token: token})
+	}()
+	return events
+}
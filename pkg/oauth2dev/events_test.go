@@ -0,0 +1,98 @@
+package oauth2dev_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+)
+
+// collect receives events until the channel is closed.
+func collect(t *testing.T, events <-chan oauth2dev.PollEvent) []oauth2dev.PollEvent {
+	t.Helper()
+	var got []oauth2dev.PollEvent
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return got
+			}
+			got = append(got, ev)
+		case <-timeout:
+			t.Fatal("events channel not closed")
+		}
+	}
+}
+
+func TestPollEvents(t *testing.T) {
+	s := newTestServer(t, pending, slowDown, token)
+	var progress []oauth2dev.Progress
+	s.config.OnProgress = func(p oauth2dev.Progress) { progress = append(progress, p) }
+
+	events := collect(t, oauth2dev.PollEvents(context.Background(), s.Client(), s.config, testCode()))
+	var states []oauth2dev.PollState
+	for _, ev := range events {
+		states = append(states, ev.State)
+	}
+	want := []oauth2dev.PollState{oauth2dev.PollPending, oauth2dev.PollSlowDown, oauth2dev.PollToken}
+	if len(states) != len(want) || states[0] != want[0] || states[1] != want[1] || states[2] != want[2] {
+		t.Fatalf("states = %v, want %v", states, want)
+	}
+	if events[0].Progress.Remaining != 600*time.Second || events[1].Progress.Interval != 10*time.Second {
+		t.Errorf("progress = %+v, %+v", events[0].Progress, events[1].Progress)
+	}
+	if events[2].Token == nil || events[2].Token.AccessToken != "test-access-token" {
+		t.Errorf("token event = %+v", events[2])
+	}
+	if len(progress) != 2 {
+		t.Errorf("caller's OnProgress called %v times, want 2", len(progress))
+	}
+}
+
+func TestPollEventsRemaining(t *testing.T) {
+	s := newTestServer(t, pending, slowDown, pending, response{http.StatusTooManyRequests, ``, nil}, pending, token)
+	events := collect(t, oauth2dev.PollEvents(context.Background(), s.Client(), s.config, testCode()))
+	if len(events) != 6 {
+		t.Fatalf("%v events, want 6: %+v", len(events), events)
+	}
+
+	// Every poll which does not finish the flow is followed by a sleep, so
+	// the time left shrinks from one event to the next.
+	if got := events[0].Progress.Remaining; got != 600*time.Second {
+		t.Errorf("first event Remaining = %v, want 600s", got)
+	}
+	for i := 1; i < 5; i++ {
+		if prev, r := events[i-1].Progress.Remaining, events[i].Progress.Remaining; r <= 0 || r >= prev {
+			t.Errorf("event %d (%v) Remaining = %v, want less than %v", i, events[i].State, r, prev)
+		}
+	}
+}
+
+func TestPollEventsError(t *testing.T) {
+	s := newTestServer(t, pending, response{http.StatusBadRequest, `{"error":"access_denied"}`, nil})
+	events := collect(t, oauth2dev.PollEvents(context.Background(), s.Client(), s.config, testCode()))
+	if last := events[len(events)-1]; last.State != oauth2dev.PollError || !errors.Is(last.Err, oauth2dev.ErrAccessDenied) {
+		t.Errorf("last event = %+v, want a PollError of ErrAccessDenied", last)
+	}
+
+	events = collect(t, oauth2dev.PollEvents(context.Background(), s.Client(), nil, testCode()))
+	if len(events) != 1 || events[0].State != oauth2dev.PollError {
+		t.Errorf("events for a nil Config = %+v, want one PollError", events)
+	}
+}
+
+func TestPollEventsCancelled(t *testing.T) {
+	s := newTestServer(t, pending)
+	ctx, cancel := context.WithCancel(context.Background())
+	events := oauth2dev.PollEvents(ctx, s.Client(), s.config, testCode())
+
+	// The loop blocks sending the first event until it is received or ctx
+	// is done.
+	<-events
+	cancel()
+	collect(t, events)
+}
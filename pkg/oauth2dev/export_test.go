@@ -0,0 +1,73 @@
+package oauth2dev
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A FakeClock is a clock whose time only advances when it is slept on, so that
+// tests of polling in package oauth2dev_test run instantly and
+// deterministically.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+// NewFakeClock returns a FakeClock set to the current time, so that tokens
+// obtained with it are still valid by the real clock.
+func NewFakeClock() *FakeClock {
+	return &FakeClock{now: time.Now()}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+	return nil
+}
+
+// Sleeps returns the durations c has been slept for, in order.
+func (c *FakeClock) Sleeps() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.sleeps...)
+}
+
+// Total returns the sum of the durations c has been slept for.
+func (c *FakeClock) Total() time.Duration {
+	var total time.Duration
+	for _, d := range c.Sleeps() {
+		total += d
+	}
+	return total
+}
+
+// SetClock makes config use clk in place of the real clock, or the real clock
+// again if clk is nil.
+func SetClock(config *Config, clk *FakeClock) {
+	if clk == nil {
+		config.clk = nil
+		return
+	}
+	config.clk = clk
+}
+
+// SetOpenBrowser makes AuthorizeWithBrowser open URLs with open, returning a
+// function which restores the real browser.
+func SetOpenBrowser(open func(url string) error) (restore func()) {
+	saved := openBrowser
+	openBrowser = open
+	return func() { openBrowser = saved }
+}
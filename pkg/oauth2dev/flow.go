@@ -0,0 +1,89 @@
+package oauth2dev
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// An IDTokenError is returned by RunDeviceFlow when the user authorized the
+// app but the ID token in the token response is missing or fails
+// verification.
+type IDTokenError struct {
+	Err error
+}
+
+func (e *IDTokenError) Error() string {
+	return "verifying ID token: " + e.Err.Error()
+}
+
+func (e *IDTokenError) Unwrap() error {
+	return e.Err
+}
+
+// An AuthorizeResult holds everything obtained by RunDeviceFlow.
+type AuthorizeResult struct {
+	Token *oauth2.Token
+	// IDToken is the verified ID token, and RawIDToken its encoded form.
+	// They are only empty if the openid scope was not requested.
+	IDToken    *oidc.IDToken
+	RawIDToken string
+	// Scopes are the scopes granted, as reported by CompareScopes.
+	Scopes []string
+	// Duration is the time taken by the whole flow, including waiting for
+	// the user.
+	Duration time.Duration
+}
+
+// RunDeviceFlow runs the whole device flow against an OpenID Connect provider
+// and verifies the resulting ID token. Any endpoints not set in config are
+// taken from provider's discovery document; config itself is not modified.
+// Like oidc.NewProvider, it uses the HTTP client set on ctx with
+// oidc.ClientContext, or http.DefaultClient.
+//
+// If the user denies access the error matches ErrAccessDenied, and if the
+// device code expires it is ErrDeviceCodeExpired. If the ID token is missing,
+// although the openid scope was requested, or invalid the error is an
+// *IDTokenError.
+func RunDeviceFlow(ctx context.Context, provider *oidc.Provider, config *Config, prompt func(*DeviceCode)) (*AuthorizeResult, error) {
+	if config == nil || config.Config == nil {
+		return nil, config.Validate()
+	}
+	start := config.clock().Now()
+	c := config.Clone()
+	if c.Endpoint.TokenURL == "" {
+		c.Endpoint = provider.Endpoint()
+	}
+	if c.DeviceEndpoint.CodeURL == "" {
+		deviceEndpoint, err := DeviceEndpointFromProvider(provider)
+		if err != nil {
+			return nil, err
+		}
+		c.DeviceEndpoint = deviceEndpoint
+	}
+
+	client, _ := ctx.Value(oauth2.HTTPClient).(*http.Client)
+	token, err := Authorize(ctx, client, c, prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &AuthorizeResult{Token: token}
+	result.Scopes, _ = CompareScopes(c.Scopes, token)
+	idToken, err := VerifyIDToken(ctx, provider, c, token)
+	switch {
+	case err == nil:
+		result.IDToken = idToken
+		result.RawIDToken, _ = token.Extra("id_token").(string)
+	case errors.Is(err, ErrNoIDToken) && !slices.Contains(c.Scopes, oidc.ScopeOpenID):
+	default:
+		return nil, &IDTokenError{Err: err}
+	}
+	result.Duration = c.clock().Now().Sub(start)
+	return result, nil
+}
@@ -0,0 +1,121 @@
+package oauth2dev_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev/oauth2devtest"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// runDeviceFlow runs RunDeviceFlow for "test-client" with scopes against a
+// provider whose device and token endpoints are those of a device flow server
+// started with opts. If idToken is not nil, the server also issues the ID
+// token it returns for the provider's URL.
+func runDeviceFlow(t *testing.T, opts oauth2devtest.Options, idToken func(issuer string) string, scopes ...string) (*oauth2dev.AuthorizeResult, *oauth2dev.FakeClock, error) {
+	t.Helper()
+	// The ID token names the provider, which is only started afterwards.
+	if opts.TokenExtra == nil {
+		opts.TokenExtra = map[string]interface{}{}
+	}
+	dev := oauth2devtest.NewServer(opts)
+	t.Cleanup(dev.Close)
+	srv := discoveryServer(t, map[string]interface{}{
+		"token_endpoint":                dev.URL + "/token",
+		"device_authorization_endpoint": dev.URL + "/device",
+		"grant_types_supported":         []string{"urn:ietf:params:oauth:grant-type:device_code"},
+	})
+	if idToken != nil {
+		opts.TokenExtra["id_token"] = idToken(srv.URL)
+	}
+
+	ctx := context.Background()
+	provider, err := oidc.NewProvider(ctx, srv.URL)
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	config := &oauth2dev.Config{Config: &oauth2.Config{ClientID: "test-client", Scopes: scopes}}
+	clk := oauth2dev.NewFakeClock()
+	oauth2dev.SetClock(config, clk)
+
+	result, err := oauth2dev.RunDeviceFlow(ctx, provider, config, nil)
+	if config.Endpoint.TokenURL != "" || config.DeviceEndpoint.CodeURL != "" {
+		t.Errorf("RunDeviceFlow modified config: %+v", config)
+	}
+	return result, clk, err
+}
+
+func TestRunDeviceFlow(t *testing.T) {
+	var raw string
+	result, clk, err := runDeviceFlow(t, oauth2devtest.Options{
+		Pending:    2,
+		Interval:   5,
+		TokenExtra: map[string]interface{}{"scope": "openid"},
+	}, func(issuer string) string {
+		raw = signIDToken(t, testKey(), idTokenClaims(issuer))
+		return raw
+	}, "openid", "email")
+	if err != nil {
+		t.Fatalf("RunDeviceFlow: %v", err)
+	}
+
+	if result.Token.AccessToken != "test-access-token" {
+		t.Errorf("Token = %+v", result.Token)
+	}
+	if result.IDToken == nil || result.IDToken.Subject != "test-subject" || result.RawIDToken != raw {
+		t.Errorf("IDToken = %+v, RawIDToken = %q", result.IDToken, result.RawIDToken)
+	}
+	if want := []string{"openid"}; !reflect.DeepEqual(result.Scopes, want) {
+		t.Errorf("Scopes = %v, want %v", result.Scopes, want)
+	}
+	if result.Duration != clk.Total() || result.Duration == 0 {
+		t.Errorf("Duration = %v, want the %v slept", result.Duration, clk.Total())
+	}
+}
+
+func TestRunDeviceFlowNoOpenID(t *testing.T) {
+	// Without the openid scope there need be no ID token.
+	result, _, err := runDeviceFlow(t, oauth2devtest.Options{}, nil, "email")
+	if err != nil {
+		t.Fatalf("RunDeviceFlow: %v", err)
+	}
+	if result.IDToken != nil || result.RawIDToken != "" {
+		t.Errorf("IDToken = %+v, RawIDToken = %q, want none", result.IDToken, result.RawIDToken)
+	}
+}
+
+func TestRunDeviceFlowIDTokenError(t *testing.T) {
+	// The openid scope was requested but no ID token was issued.
+	_, _, err := runDeviceFlow(t, oauth2devtest.Options{}, nil, "openid")
+	var idErr *oauth2dev.IDTokenError
+	if !errors.As(err, &idErr) || !errors.Is(err, oauth2dev.ErrNoIDToken) {
+		t.Errorf("error = %v, want an *IDTokenError wrapping ErrNoIDToken", err)
+	}
+
+	// The ID token was issued for another client.
+	_, _, err = runDeviceFlow(t, oauth2devtest.Options{}, func(issuer string) string {
+		claims := idTokenClaims(issuer)
+		claims["aud"] = "other-client"
+		return signIDToken(t, testKey(), claims)
+	}, "openid")
+	if !errors.As(err, &idErr) || errors.Is(err, oauth2dev.ErrNoIDToken) {
+		t.Errorf("error = %v, want an *IDTokenError for the audience", err)
+	}
+}
+
+func TestRunDeviceFlowDenied(t *testing.T) {
+	_, _, err := runDeviceFlow(t, oauth2devtest.Options{Pending: 1, Error: "access_denied"}, nil, "openid")
+	if !errors.Is(err, oauth2dev.ErrAccessDenied) {
+		t.Errorf("error = %v, want ErrAccessDenied", err)
+	}
+}
+
+func TestRunDeviceFlowNilConfig(t *testing.T) {
+	if _, err := oauth2dev.RunDeviceFlow(context.Background(), nil, nil, nil); err == nil {
+		t.Error("RunDeviceFlow with a nil Config succeeded")
+	}
+}
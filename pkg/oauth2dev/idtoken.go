@@ -0,0 +1,42 @@
+package oauth2dev
+
+import (
+	"context"
+	"errors"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	// ErrNoIDToken is an error returned when a token response does not
+	// include an ID token.
+	ErrNoIDToken = errors.New("token response has no id_token")
+
+	// ErrNonceMismatch is an error returned when an ID token's nonce claim
+	// does not match Config.Nonce.
+	ErrNonceMismatch = errors.New("id_token nonce does not match")
+)
+
+// VerifyIDToken extracts the ID token from a token returned by the device
+// flow and verifies it against provider's keys, with config's client ID as the
+// expected audience. If the token has no ID token, the error is ErrNoIDToken.
+// If config.Nonce is set and the ID token's nonce claim differs, the error is
+// ErrNonceMismatch.
+// The ID token's claims may be read with (*oidc.IDToken).Claims.
+func VerifyIDToken(ctx context.Context, provider *oidc.Provider, config *Config, token *oauth2.Token) (*oidc.IDToken, error) {
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, ErrNoIDToken
+	}
+
+	verifier := provider.Verifier(&oidc.Config{ClientID: config.ClientID})
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if config.Nonce != "" && idToken.Nonce != config.Nonce {
+		return nil, ErrNonceMismatch
+	}
+	return idToken, nil
+}
@@ -0,0 +1,139 @@
+package oauth2dev_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	testKeyOnce sync.Once
+	testKeyRSA  *rsa.PrivateKey
+)
+
+// testKey returns the key with which discoveryServer's ID tokens are signed.
+func testKey() *rsa.PrivateKey {
+	testKeyOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			panic(err)
+		}
+		testKeyRSA = key
+	})
+	return testKeyRSA
+}
+
+// testJWKS returns a JSON Web Key Set holding pub.
+func testJWKS(pub *rsa.PublicKey) map[string]interface{} {
+	b64 := base64.RawURLEncoding.EncodeToString
+	return map[string]interface{}{"keys": []map[string]interface{}{{
+		"kty": "RSA",
+		"alg": "RS256",
+		"use": "sig",
+		"kid": "test-key",
+		"n":   b64(pub.N.Bytes()),
+		"e":   b64(big.NewInt(int64(pub.E)).Bytes()),
+	}}}
+}
+
+// signIDToken returns an RS256 JWT with the given claims signed by key.
+func signIDToken(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test-key", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// idTokenClaims returns the claims of a valid ID token from issuer for
+// "test-client".
+func idTokenClaims(issuer string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss": issuer,
+		"aud": "test-client",
+		"sub": "test-subject",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	srv := discoveryServer(t, nil)
+	ctx := context.Background()
+	provider, err := oidc.NewProvider(ctx, srv.URL)
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	config := &oauth2dev.Config{Config: &oauth2.Config{ClientID: "test-client"}}
+
+	withIDToken := func(raw string) *oauth2.Token {
+		return (&oauth2.Token{AccessToken: "test-access-token"}).WithExtra(map[string]interface{}{"id_token": raw})
+	}
+
+	claims := idTokenClaims(srv.URL)
+	claims["nonce"] = "test-nonce"
+	idToken, err := oauth2dev.VerifyIDToken(ctx, provider, config, withIDToken(signIDToken(t, testKey(), claims)))
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	if idToken.Subject != "test-subject" || idToken.Issuer != srv.URL || idToken.Nonce != "test-nonce" {
+		t.Errorf("IDToken = %+v", idToken)
+	}
+
+	// The nonce is only checked when Config.Nonce is set.
+	config.Nonce = "test-nonce"
+	if _, err := oauth2dev.VerifyIDToken(ctx, provider, config, withIDToken(signIDToken(t, testKey(), claims))); err != nil {
+		t.Errorf("VerifyIDToken with the matching nonce: %v", err)
+	}
+	config.Nonce = "other-nonce"
+	if _, err := oauth2dev.VerifyIDToken(ctx, provider, config, withIDToken(signIDToken(t, testKey(), claims))); !errors.Is(err, oauth2dev.ErrNonceMismatch) {
+		t.Errorf("VerifyIDToken with another nonce error = %v, want ErrNonceMismatch", err)
+	}
+	config.Nonce = ""
+
+	if _, err := oauth2dev.VerifyIDToken(ctx, provider, config, &oauth2.Token{AccessToken: "test-access-token"}); !errors.Is(err, oauth2dev.ErrNoIDToken) {
+		t.Errorf("VerifyIDToken without an ID token error = %v, want ErrNoIDToken", err)
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongAudience := idTokenClaims(srv.URL)
+	wrongAudience["aud"] = "other-client"
+	expired := idTokenClaims(srv.URL)
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	for name, raw := range map[string]string{
+		"other key":      signIDToken(t, otherKey, idTokenClaims(srv.URL)),
+		"other audience": signIDToken(t, testKey(), wrongAudience),
+		"other issuer":   signIDToken(t, testKey(), idTokenClaims("https://example.com")),
+		"expired":        signIDToken(t, testKey(), expired),
+		"malformed":      "not-a-jwt",
+	} {
+		if _, err := oauth2dev.VerifyIDToken(ctx, provider, config, withIDToken(raw)); err == nil {
+			t.Errorf("VerifyIDToken of an ID token with %s succeeded", name)
+		}
+	}
+}
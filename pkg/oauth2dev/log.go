@@ -0,0 +1,77 @@
+package oauth2dev
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// maxLogBodySize is the most of a response body read for logging.
+const maxLogBodySize = 64 << 10
+
+// redactedFields are the response fields never written to Config.Logger.
+var redactedFields = []string{
+	"access_token",
+	"refresh_token",
+	"id_token",
+	"client_secret",
+	"device_code",
+}
+
+// logResponse writes a debug record of req and its response, or err, to
+// c.Logger if set. The body of resp is read and replaced so that the caller
+// can still read it in full.
+func (c *Config) logResponse(req *http.Request, resp *http.Response, err error) {
+	if c.Logger == nil {
+		return
+	}
+	ctx := req.Context()
+	if err != nil {
+		c.Logger.DebugContext(ctx, "oauth2dev: request failed",
+			slog.String("method", req.Method),
+			slog.String("url", req.URL.Redacted()),
+			slog.String("error", err.Error()))
+		return
+	}
+	if !c.Logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxLogBodySize))
+	resp.Body = &prefixBody{Reader: io.MultiReader(bytes.NewReader(body), resp.Body), Closer: resp.Body}
+
+	c.Logger.DebugContext(ctx, "oauth2dev: response",
+		slog.String("method", req.Method),
+		slog.String("url", req.URL.Redacted()),
+		slog.Int("status", resp.StatusCode),
+		slog.String("body", redactBody(body)))
+}
+
+// redactBody returns body with the values of redactedFields replaced. Bodies
+// which are not JSON objects are omitted entirely, as they cannot be redacted
+// safely.
+func redactBody(body []byte) string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return "(not a JSON object)"
+	}
+	for _, k := range redactedFields {
+		if _, ok := fields[k]; ok {
+			fields[k] = json.RawMessage(`"REDACTED"`)
+		}
+	}
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return "(not a JSON object)"
+	}
+	return string(b)
+}
+
+// A prefixBody is a response body whose start has already been read and is
+// replayed from Reader.
+type prefixBody struct {
+	io.Reader
+	io.Closer
+}
@@ -1,13 +1,23 @@
 package oauth2dev
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"golang.org/x/oauth2"
 )
@@ -15,6 +25,11 @@ import (
 // A DeviceCode represents the user-visible code, verification URL and
 // device-visible code used to allow for user authorisation of this app. The
 // app should show UserCode and VerificationURL to the user.
+//
+// A DeviceCode may be saved with json.Marshal and, once decoded again, passed
+// to WaitForDeviceAuthorization to resume polling after a restart, in case
+// the user authorized the app in the meantime. Its absolute Expiry is kept, so
+// a code which has since expired fails at once with ErrDeviceCodeExpired.
 type DeviceCode struct {
 	DeviceCode              string `json:"device_code"`
 	UserCode                string `json:"user_code"`
@@ -22,6 +37,208 @@ type DeviceCode struct {
 	VerificationURLComplete string `json:"verification_uri_complete"`
 	ExpiresIn               int64  `json:"expires_in"`
 	Interval                int64  `json:"interval"`
+
+	// Message, if set by the provider (as Microsoft does), is a
+	// human-readable instruction telling the user what to do.
+	Message string `json:"message,omitempty"`
+
+	// Expiry is the time at which the device code expires, computed by
+	// RequestDeviceCode from ExpiresIn. It is zero if the provider did not
+	// say when the code expires.
+	Expiry time.Time `json:"expiry"`
+}
+
+// Expired reports whether the device code has expired.
+func (d *DeviceCode) Expired() bool {
+	return !d.Expiry.IsZero() && time.Now().After(d.Expiry)
+}
+
+// UnmarshalJSON decodes a device authorization response. In addition to the
+// RFC 8628 field names it accepts the verification_url and
+// verification_url_complete names used by Google, and expires_in and interval
+// encoded as strings.
+func (d *DeviceCode) UnmarshalJSON(data []byte) error {
+	type deviceCode DeviceCode
+	var v struct {
+		deviceCode
+		VerificationURLAlt         string  `json:"verification_url"`
+		VerificationURLCompleteAlt string  `json:"verification_url_complete"`
+		ExpiresIn                  jsonInt `json:"expires_in"`
+		Interval                   jsonInt `json:"interval"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	if v.VerificationURL == "" {
+		v.VerificationURL = v.VerificationURLAlt
+	}
+	if v.VerificationURLComplete == "" {
+		v.VerificationURLComplete = v.VerificationURLCompleteAlt
+	}
+	v.deviceCode.ExpiresIn = int64(v.ExpiresIn)
+	v.deviceCode.Interval = int64(v.Interval)
+	*d = DeviceCode(v.deviceCode)
+	return nil
+}
+
+// An htmlText is a JSON string which some providers HTML-escape, such as an
+// error_description of "can&#39;t". Entities are unescaped when it is decoded.
+type htmlText string
+
+func (t *htmlText) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*t = htmlText(html.UnescapeString(s))
+	return nil
+}
+
+// A jsonInt is an integer which may be encoded either as a JSON number or, as
+// some providers do, a string.
+type jsonInt int64
+
+func (n *jsonInt) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+	i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid integer %s", data)
+	}
+	*n = jsonInt(i)
+	return nil
+}
+
+// VerificationURLParsed parses and validates VerificationURL. (It cannot be
+// called VerificationURL, as that is the name of the field.)
+func (d *DeviceCode) VerificationURLParsed() (*url.URL, error) {
+	return parseVerificationURL("verification_uri", d.VerificationURL)
+}
+
+// VerificationURLCompleteParsed parses and validates VerificationURLComplete.
+func (d *DeviceCode) VerificationURLCompleteParsed() (*url.URL, error) {
+	return parseVerificationURL("verification_uri_complete", d.VerificationURLComplete)
+}
+
+// parseVerificationURL parses s, the value of the named field, returning an
+// error unless it is an absolute http or https URL.
+func parseVerificationURL(field, s string) (*url.URL, error) {
+	if s == "" {
+		return nil, fmt.Errorf("device code has no %v", field)
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("device code has invalid %v: %w", field, err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("device code has invalid %v %q: not an absolute http or https URL", field, s)
+	}
+	return u, nil
+}
+
+// validate returns an error naming any fields required by RFC 8628 which are
+// missing from a device authorization response.
+func (d *DeviceCode) validate() error {
+	var missing []string
+	if d.DeviceCode == "" {
+		missing = append(missing, "device_code")
+	}
+	if d.UserCode == "" {
+		missing = append(missing, "user_code")
+	}
+	if d.VerificationURL == "" && d.VerificationURLComplete == "" {
+		missing = append(missing, "verification_uri")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("device code response is missing %v", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// UserInstructions returns a message telling the user how to authorize this
+// app. If the provider returned a Message that is used as is. Otherwise, if
+// the provider returned VerificationURLComplete the user only needs to visit
+// it; otherwise they must visit VerificationURL and enter UserCode.
+func (d *DeviceCode) UserInstructions() string {
+	if d.Message != "" {
+		return d.Message
+	}
+	if d.VerificationURLComplete != "" {
+		return fmt.Sprintf("Visit %v and check that it shows the code %v",
+			d.VerificationURLComplete, d.UserCode)
+	}
+	return fmt.Sprintf("Visit %v and enter the code %v", d.VerificationURL, d.UserCode)
+}
+
+// PlainUserCode returns UserCode with any hyphens and whitespace removed, for
+// copying to the clipboard or entering on a keypad.
+func (d *DeviceCode) PlainUserCode() string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, d.UserCode)
+}
+
+// FormattedUserCode returns UserCode formatted for display. If the provider
+// already separated it into groups, it is returned as is. Otherwise it is split
+// into hyphen-separated groups of four characters, e.g. "WDJB-MJHT", or of
+// three if its length is a multiple of three but not of four. Codes which
+// can't be split evenly are returned unchanged.
+func (d *DeviceCode) FormattedUserCode() string {
+	code := strings.TrimSpace(d.UserCode)
+	plain := d.PlainUserCode()
+	if code != plain {
+		return code
+	}
+
+	runes := []rune(plain)
+	var size int
+	switch {
+	case len(runes)%4 == 0:
+		size = 4
+	case len(runes)%3 == 0:
+		size = 3
+	}
+	if size == 0 || len(runes) <= size {
+		return plain
+	}
+
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && i%size == 0 {
+			b.WriteByte('-')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// TerminalHyperlink returns VerificationURLComplete wrapped in an OSC 8 escape
+// sequence so terminals that support it render a clickable link. If the
+// provider did not return VerificationURLComplete the plain VerificationURL is
+// returned, since the user still has to enter the code by hand.
+func (d *DeviceCode) TerminalHyperlink() string {
+	if d.VerificationURLComplete == "" {
+		return d.VerificationURL
+	}
+	// Control characters would terminate the escape sequence early.
+	link := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, d.VerificationURLComplete)
+	return "\x1b]8;;" + link + "\x1b\\" + link + "\x1b]8;;\x1b\\"
 }
 
 // DeviceEndpoint contains the URLs required to initiate the OAuth2.0 flow for a
@@ -34,108 +251,1221 @@ type DeviceEndpoint struct {
 type Config struct {
 	*oauth2.Config
 	DeviceEndpoint DeviceEndpoint
+
+	// CodeVerifier, if set, enables PKCE. The S256 challenge derived from it
+	// is sent with the device code request and the verifier itself is sent
+	// when polling for the token. See GeneratePKCE.
+	CodeVerifier string
+
+	// Nonce, if set, is sent as the OpenID Connect nonce parameter of the
+	// device code request, and VerifyIDToken then requires the ID token's
+	// nonce claim to match it. It should be a fresh random value for each
+	// flow.
+	Nonce string
+
+	// PollInterval, if non-zero, overrides the polling interval returned by
+	// the provider. If neither is set, DefaultPollInterval is used.
+	PollInterval time.Duration
+
+	// InitialPollDelay, if non-zero, is waited before the first poll, which
+	// is otherwise made at once. Waiting the poll interval, as RFC 8628
+	// allows, avoids being told to slow down by strict rate limits.
+	InitialPollDelay time.Duration
+
+	// AuthStyle selects how the client credentials are sent when polling the
+	// token URL. oauth2.AuthStyleInHeader uses HTTP Basic authentication;
+	// any other value sends them in the request body.
+	AuthStyle oauth2.AuthStyle
+
+	// TLSClientAuth selects mutual TLS client authentication
+	// (tls_client_auth, RFC 8705) at the token URL. The client secret is
+	// then never sent, and AuthStyle is ignored; only the client ID is sent
+	// and the provider authenticates the client by the certificate it
+	// presents. That certificate must be configured on the TLS transport of
+	// the *http.Client passed to this package.
+	TLSClientAuth bool
+
+	// ClientAssertion, if set, selects private_key_jwt client
+	// authentication (RFC 7523) at the token URL. It is called before each
+	// request to the token URL and returns a signed JWT, which is sent as
+	// client_assertion in place of the client secret; AuthStyle is then
+	// ignored. Each call should return a fresh assertion, as providers may
+	// reject a reused jti.
+	ClientAssertion func(ctx context.Context) (string, error)
+
+	// DeviceRequestParams holds additional parameters, such as audience or
+	// resource, to send with the device code request. They cannot override
+	// the parameters set by this package.
+	DeviceRequestParams url.Values
+
+	// TokenRequestParams holds additional parameters to send with every
+	// request to the token URL, both when polling and when refreshing. Like
+	// DeviceRequestParams, they cannot override the parameters set by this
+	// package.
+	TokenRequestParams url.Values
+
+	// AccessTokenAudience, if set, is checked against the aud claim of the
+	// access token returned by WaitForDeviceAuthorization if it is a JWT; see
+	// CheckAccessTokenAudience.
+	AccessTokenAudience string
+
+	// ScopeSeparator joins Scopes in the scope parameter of the device code
+	// request. If empty, a space is used as RFC 6749 requires; a few legacy
+	// providers expect a comma or plus sign instead.
+	ScopeSeparator string
+
+	// SendScopeOnTokenRequest, if true, sends Scopes in a scope parameter
+	// when polling as well as on the device code request, as a few providers
+	// require.
+	SendScopeOnTokenRequest bool
+
+	// DeviceGrantType, if set, replaces the RFC 8628 grant type,
+	// "urn:ietf:params:oauth:grant-type:device_code", sent when polling, for
+	// providers predating the standard such as those expecting
+	// "http://oauth.net/grant_type/device/1.0".
+	DeviceGrantType string
+
+	// Resources lists the RFC 8707 resource indicators, as absolute URIs,
+	// of the resource servers the token is for. Each is sent as a resource
+	// parameter on both the device code request and the token polls.
+	Resources []string
+
+	// RequestEncoding selects how the device code request body is encoded.
+	// The default is RequestEncodingForm.
+	RequestEncoding RequestEncoding
+
+	// RequestTimeout, if non-zero, limits the time taken by each individual
+	// HTTP request, including reading its response body. It does not affect
+	// the interval between polls, nor the overall time spent waiting for the
+	// user to authorize the app; use a context deadline for that.
+	RequestTimeout time.Duration
+
+	// MaxResponseSize is the largest response body, in bytes, that is read
+	// from the device code and token URLs, so that a broken or hostile
+	// provider cannot exhaust memory. If zero, DefaultMaxResponseSize is
+	// used. Larger responses fail with ErrResponseTooLarge.
+	MaxResponseSize int64
+
+	// PollJitter enables randomising each poll interval by up to
+	// PollJitterFraction in either direction, so that many devices starting
+	// the flow together don't poll in lockstep.
+	PollJitter bool
+
+	// PollJitterFraction is the largest fraction of the poll interval added
+	// or removed by PollJitter. If zero, DefaultPollJitterFraction is used.
+	PollJitterFraction float64
+
+	// MinPollInterval is the shortest poll interval accepted from the
+	// provider; shorter or negative intervals are raised to it. If zero,
+	// DefaultMinPollInterval is used. It does not apply to PollInterval.
+	MinPollInterval time.Duration
+
+	// MaxPollInterval caps the poll interval as it is doubled in response to
+	// slow_down errors. If zero, DefaultMaxPollInterval is used. It never
+	// shortens an interval requested by the provider.
+	MaxPollInterval time.Duration
+
+	// PendingStatusCodes lists the HTTP statuses, besides 200 and 401, whose
+	// token URL responses are decoded as OAuth2 error responses. If nil,
+	// DefaultPendingStatusCodes is used. Responses with a status other than
+	// 400 which do not carry an OAuth2 error are taken to mean
+	// authorization_pending. The list should normally include 400, which
+	// RFC 8628 uses for every error.
+	PendingStatusCodes []int
+
+	// UserAgent, if set, is sent as the User-Agent header of every request.
+	UserAgent string
+
+	// RequestDecorator, if set, is called with every request this package
+	// makes just before it is sent, for example to add a DPoP proof or a
+	// correlation ID header. If it returns an error the request is not sent
+	// and the error is returned.
+	RequestDecorator func(*http.Request) error
+
+	// MaxTransportRetries is the number of times in a row a token poll is
+	// retried, with exponential backoff, after a transient network error
+	// such as a refused connection or a DNS failure. If zero,
+	// DefaultMaxTransportRetries is used; if negative, there are no retries.
+	MaxTransportRetries int
+
+	// MaxPollAttempts, if positive, limits the number of polls of the token
+	// URL. Once that many polls have not finished the flow,
+	// WaitForDeviceAuthorization returns ErrMaxPollAttempts. Retries after
+	// network errors do not count.
+	MaxPollAttempts int
+
+	// ErrorMapper, if set, is called with each error code returned when
+	// polling the token URL, before this package handles it, so that it can
+	// remap standard codes such as access_denied as well as provider-specific
+	// ones. A non-nil result is returned by WaitForDeviceAuthorization. On
+	// nil, codes this package recognises are handled as usual and any other
+	// means keep polling; without an ErrorMapper those cause an
+	// *AuthorizationError.
+	ErrorMapper func(code, description string) error
+
+	// Logger, if set, receives a debug record of each request made: its
+	// URL, the response status and the response body with any tokens and
+	// secrets redacted.
+	Logger *slog.Logger
+
+	// OnWarning, if set, is called with problems which do not stop the flow
+	// but suggest a misconfigured provider, such as ErrShortExpiry.
+	OnWarning func(err error)
+
+	// OnProgress, if set, is called after each poll of the token URL which
+	// did not finish the flow, for example to update a spinner. It is called
+	// synchronously from the polling loop and so must return promptly.
+	OnProgress func(Progress)
+
+	// OnCountdown, if set, is called every CountdownInterval while waiting
+	// between polls with the time left until the device code expires, for
+	// example to show a countdown. It does not change when polls are made,
+	// and is not called if the expiry of the device code is unknown.
+	OnCountdown func(remaining time.Duration)
+
+	// CountdownInterval is the cadence of OnCountdown. If zero,
+	// DefaultCountdownInterval is used.
+	CountdownInterval time.Duration
+
+	// The following hooks, if set, are called as the flow proceeds so that
+	// callers can record metrics. Like OnProgress they are called
+	// synchronously and must return promptly.
+	//
+	// OnDeviceCodeRequested is called when RequestDeviceCode succeeds, with
+	// the time taken by the request. OnPoll is called after each response
+	// from the token URL. OnTokenReceived is called when
+	// WaitForDeviceAuthorization succeeds, with the time spent waiting for
+	// the user. OnError is called with any error returned by either.
+	OnDeviceCodeRequested func(code *DeviceCode, elapsed time.Duration)
+	OnPoll                func(PollInfo)
+	OnTokenReceived       func(token *oauth2.Token, elapsed time.Duration)
+	OnError               func(err error)
+
+	// clk, if set, replaces the real clock in tests.
+	clk clock
+}
+
+// RequestEncoding represents how request parameters are encoded in the body of
+// the device code request.
+type RequestEncoding int
+
+const (
+	// RequestEncodingForm encodes parameters as
+	// application/x-www-form-urlencoded, as required by RFC 8628.
+	RequestEncodingForm RequestEncoding = iota
+
+	// RequestEncodingJSON encodes parameters as an application/json object
+	// for providers which require it.
+	RequestEncodingJSON
+)
+
+// Clone returns a copy of c which can be modified without affecting c. The
+// embedded oauth2.Config and every slice and map field are copied too; hooks
+// and the Logger are shared.
+func (c *Config) Clone() *Config {
+	if c == nil {
+		return nil
+	}
+	clone := *c
+	if c.Config != nil {
+		oc := *c.Config
+		oc.Scopes = slices.Clone(c.Scopes)
+		clone.Config = &oc
+	}
+	clone.DeviceRequestParams = cloneValues(c.DeviceRequestParams)
+	clone.TokenRequestParams = cloneValues(c.TokenRequestParams)
+	clone.Resources = slices.Clone(c.Resources)
+	clone.PendingStatusCodes = slices.Clone(c.PendingStatusCodes)
+	return &clone
+}
+
+// cloneValues returns a deep copy of v, or nil if v is nil.
+func cloneValues(v url.Values) url.Values {
+	if v == nil {
+		return nil
+	}
+	clone := make(url.Values, len(v))
+	for k, vs := range v {
+		clone[k] = slices.Clone(vs)
+	}
+	return clone
+}
+
+// Validate reports whether c has the fields required for the device flow: a
+// client ID, an absolute device code URL and a token URL.
+func (c *Config) Validate() error {
+	if c == nil {
+		return errors.New("config is nil")
+	}
+	if c.Config == nil {
+		return errors.New("config has no OAuth2 configuration")
+	}
+	if c.ClientID == "" {
+		return errors.New("config has no client ID")
+	}
+	if c.DeviceEndpoint.CodeURL == "" {
+		return errors.New("config has no device code URL")
+	}
+	if u, err := url.Parse(c.DeviceEndpoint.CodeURL); err != nil || !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("config has invalid device code URL %q", c.DeviceEndpoint.CodeURL)
+	}
+	if c.Endpoint.TokenURL == "" {
+		return errors.New("config has no token URL")
+	}
+	return nil
+}
+
+// A Progress describes the state of WaitForDeviceAuthorization after a poll of
+// the token URL which did not finish the flow.
+type Progress struct {
+	// Attempt is the number of polls made so far.
+	Attempt int
+	// Interval is the time until the next poll.
+	Interval time.Duration
+	// SlowDown reports whether the provider asked for polling to slow down.
+	SlowDown bool
+	// Remaining is the time until the device code expires, or zero if its
+	// expiry is unknown.
+	Remaining time.Duration
+}
+
+// A PollInfo describes a single poll of the token URL.
+type PollInfo struct {
+	// Attempt is the number of polls made so far, including this one.
+	Attempt int
+	// StatusCode is the HTTP status of the response.
+	StatusCode int
+	// Duration is the time taken to receive the response.
+	Duration time.Duration
 }
 
 // A tokenOrError is either an OAuth2 Token response or an error indicating why
 // such a response failed.
 type tokenOrError struct {
 	*oauth2.Token
-	Error            string `json:"error,omitempty"`
-	ErrorDescription string `json:"error_description,omitempty"`
-	ExpiresIn        int64  `json:"expires_in"`
+	Error            string   `json:"error,omitempty"`
+	ErrorDescription htmlText `json:"error_description,omitempty"`
+	ErrorURI         string   `json:"error_uri,omitempty"`
+	ExpiresIn        jsonInt  `json:"expires_in"`
+	// Interval is set by providers which change the poll interval in an
+	// error response.
+	Interval jsonInt `json:"interval"`
+	// DeviceCode is set by providers which rotate the device code in an
+	// error response.
+	DeviceCode string `json:"device_code"`
 }
 
 var (
-	// ErrAccessDenied is an error returned when the user has denied this
-	// app access to their account.
+	// ErrAccessDenied matches, with errors.Is, the error returned when the
+	// user has denied this app access to their account.
 	ErrAccessDenied = errors.New("access denied by user")
+
+	// ErrDeviceCodeExpired is an error returned when the device code expired
+	// before the user authorized this app.
+	ErrDeviceCodeExpired = errors.New("device code expired")
+
+	// ErrInterrupted is an error returned by WaitForDeviceAuthorizationDone
+	// when its done channel is closed.
+	ErrInterrupted = errors.New("interrupted waiting for device authorization")
+
+	// ErrShortExpiry is passed, wrapped, to Config.OnWarning when a device
+	// code expires in less than two poll intervals, too soon for the user to
+	// authorize the app.
+	ErrShortExpiry = errors.New("device code expires implausibly soon")
+
+	// ErrResponseTooLarge is an error returned, wrapped, when a response body
+	// is larger than Config.MaxResponseSize.
+	ErrResponseTooLarge = errors.New("response body too large")
+
+	// ErrMaxPollAttempts is an error returned when Config.MaxPollAttempts
+	// polls have been made without the user authorizing this app.
+	ErrMaxPollAttempts = errors.New("maximum number of token polls reached")
 )
 
+// An AuthorizationError is returned when polling the token URL fails with an
+// OAuth2 error code not otherwise handled by this package, or with
+// access_denied, in which case it matches ErrAccessDenied.
+type AuthorizationError struct {
+	// Code is the OAuth2 error code, e.g. "invalid_client".
+	Code string
+	// Description is the optional human-readable error_description.
+	Description string
+	// URI is the optional error_uri, a web page describing the error to
+	// which a CLI might refer the user.
+	URI string
+}
+
+func (e *AuthorizationError) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("authorization failed: %v", e.Code)
+	}
+	return fmt.Sprintf("authorization failed: %v: %v", e.Code, e.Description)
+}
+
+// Is reports whether target is ErrAccessDenied and e is an access_denied
+// error.
+func (e *AuthorizationError) Is(target error) bool {
+	return target == ErrAccessDenied && e.Code == "access_denied"
+}
+
+// An HTTPError describes a response with an unexpected HTTP status. It is
+// returned, possibly wrapped, whenever a request made by this package fails
+// with a status that is not part of the OAuth2 protocol, and wrapped by
+// DeviceCodeError.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	// Body holds the first maxSnippetSize bytes of the response body.
+	Body string
+}
+
+func (e *HTTPError) Error() string {
+	return "HTTP error " + e.Status
+}
+
+// newHTTPError returns an HTTPError for resp, reading at most maxSnippetSize
+// bytes of its body.
+func newHTTPError(resp *http.Response) *HTTPError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxSnippetSize))
+	return newHTTPErrorBody(resp, body)
+}
+
+// newHTTPErrorBody returns an HTTPError for resp, whose body has already been
+// read.
+func newHTTPErrorBody(resp *http.Response, body []byte) *HTTPError {
+	status := resp.Status
+	if status == "" {
+		status = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	if len(body) > maxSnippetSize {
+		body = body[:maxSnippetSize]
+	}
+	return &HTTPError{StatusCode: resp.StatusCode, Status: status, Body: string(body)}
+}
+
+// A DeviceCodeError is returned when the device code request fails with a
+// status other than 200 OK. Code and Description are filled from the OAuth2
+// error response body if the provider sent one. It wraps an *HTTPError.
+type DeviceCodeError struct {
+	StatusCode  int
+	Code        string
+	Description string
+
+	http *HTTPError
+}
+
+func (e *DeviceCodeError) Error() string {
+	msg := fmt.Sprintf("request for device code authorisation returned status %v (%v)",
+		e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	return msg
+}
+
+func (e *DeviceCodeError) Unwrap() error {
+	if e.http == nil {
+		return nil
+	}
+	return e.http
+}
+
+// newDeviceCodeError returns a DeviceCodeError for resp, reading at most
+// maxErrorBodySize bytes of its body.
+func newDeviceCodeError(resp *http.Response) *DeviceCodeError {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	e := &DeviceCodeError{
+		StatusCode: resp.StatusCode,
+		http:       newHTTPErrorBody(resp, data),
+	}
+
+	var body struct {
+		Error            string   `json:"error"`
+		ErrorDescription htmlText `json:"error_description"`
+	}
+	if err := json.Unmarshal(data, &body); err == nil {
+		e.Code = body.Error
+		e.Description = string(body.ErrorDescription)
+	}
+	return e
+}
+
+// A MalformedResponseError is returned when a response which should hold a
+// JSON object does not, for example because a proxy returned an HTML error
+// page in its place.
+type MalformedResponseError struct {
+	StatusCode  int
+	ContentType string
+	// Snippet holds the first maxSnippetSize bytes of the body.
+	Snippet string
+	Err     error
+}
+
+func (e *MalformedResponseError) Error() string {
+	return fmt.Sprintf("malformed response with status %v and content type %q: %v: %q",
+		e.StatusCode, e.ContentType, e.Err, e.Snippet)
+}
+
+func (e *MalformedResponseError) Unwrap() error {
+	return e.Err
+}
+
+// closeBody drains and closes the body of resp so that its connection can be
+// reused. Bodies longer than maxErrorBodySize are not fully drained, as it is
+// cheaper to open a new connection than to read them.
+func closeBody(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBodySize))
+	resp.Body.Close()
+}
+
+// readJSON reads the body of resp, of at most limit bytes, returning a
+// *MalformedResponseError if it is not valid JSON.
+func readJSON(resp *http.Response, limit int64) (json.RawMessage, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrResponseTooLarge, limit)
+	}
+	var raw json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		if len(body) > maxSnippetSize {
+			body = body[:maxSnippetSize]
+		}
+		return nil, &MalformedResponseError{
+			StatusCode:  resp.StatusCode,
+			ContentType: resp.Header.Get("Content-Type"),
+			Snippet:     string(body),
+			Err:         err,
+		}
+	}
+	return raw, nil
+}
+
+// DefaultPendingStatusCodes are the statuses used when
+// Config.PendingStatusCodes is nil: 400, as RFC 8628 specifies, and 428, which
+// some providers use while authorization is pending.
+var DefaultPendingStatusCodes = []int{http.StatusBadRequest, http.StatusPreconditionRequired}
+
 const (
-	deviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+	deviceGrantType     = "urn:ietf:params:oauth:grant-type:device_code"
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	// maxErrorBodySize is the most that is read from the body of an error
+	// response.
+	maxErrorBodySize = 64 << 10
+
+	// maxSnippetSize is the most of a malformed response body kept in a
+	// MalformedResponseError.
+	maxSnippetSize = 512
+
+	// DefaultPollInterval is the interval between polls of the token URL when
+	// neither the provider nor Config specify one, as per RFC 8628 section 3.5.
+	DefaultPollInterval = 5 * time.Second
+
+	// DefaultPollJitterFraction is the fraction of the poll interval used by
+	// PollJitter when Config.PollJitterFraction is not set.
+	DefaultPollJitterFraction = 0.1
+
+	// DefaultMinPollInterval is the floor on the poll interval when
+	// Config.MinPollInterval is not set.
+	DefaultMinPollInterval = time.Second
+
+	// DefaultMaxPollInterval is the cap on the poll interval when
+	// Config.MaxPollInterval is not set.
+	DefaultMaxPollInterval = 60 * time.Second
+
+	// DefaultMaxTransportRetries is the number of retries after transient
+	// network errors when Config.MaxTransportRetries is not set.
+	DefaultMaxTransportRetries = 3
+
+	// DefaultMaxResponseSize is the limit on response bodies when
+	// Config.MaxResponseSize is not set.
+	DefaultMaxResponseSize = 1 << 20
+
+	// DefaultCountdownInterval is the cadence of Config.OnCountdown when
+	// Config.CountdownInterval is not set.
+	DefaultCountdownInterval = time.Second
+
+	// initialRetryBackoff is the delay before the first retry after a
+	// transient network error. It doubles with each further retry.
+	initialRetryBackoff = time.Second
 )
 
 // RequestDeviceCode will initiate the OAuth2 device authorization flow. It
 // requests a device code and information on the code and URL to show to the
 // user. Pass the returned DeviceCode to WaitForDeviceAuthorization.
 func RequestDeviceCode(client *http.Client, config *Config) (*DeviceCode, error) {
-	scopes := strings.Join(config.Scopes, " ")
-	resp, err := client.PostForm(config.DeviceEndpoint.CodeURL,
-		url.Values{"client_id": {config.ClientID}, "scope": {scopes}})
+	return RequestDeviceCodeContext(context.Background(), client, config)
+}
 
-	if err != nil {
+// RequestDeviceCodeWithScopes is like RequestDeviceCode but requests scopes
+// instead of config.Scopes. config itself is not modified, so it may be shared
+// between flows requesting different scopes.
+func RequestDeviceCodeWithScopes(client *http.Client, config *Config, scopes []string) (*DeviceCode, error) {
+	if err := config.Validate(); err != nil {
 		return nil, err
 	}
+
+	c := config.Clone()
+	c.Scopes = scopes
+	return RequestDeviceCode(client, c)
+}
+
+// RequestDeviceCodeContext is like RequestDeviceCode but attaches ctx to the
+// outgoing request so that it may be cancelled or given a deadline.
+func RequestDeviceCodeContext(ctx context.Context, client *http.Client, config *Config) (*DeviceCode, error) {
+	return (&Client{HTTPClient: client, Config: config}).RequestDeviceCode(ctx)
+}
+
+// RequestDeviceCodeDetailed is like RequestDeviceCodeContext but also returns
+// the headers of the provider's response, such as a request ID or rate limit,
+// for logging. The headers are returned whenever a response was received, even
+// if it was an error.
+func RequestDeviceCodeDetailed(ctx context.Context, client *http.Client, config *Config) (*DeviceCode, http.Header, error) {
+	return (&Client{HTTPClient: client, Config: config}).RequestDeviceCodeDetailed(ctx)
+}
+
+func requestDeviceCode(ctx context.Context, client *http.Client, config *Config) (*DeviceCode, http.Header, error) {
+	if err := config.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	params := url.Values{}
+	for k, v := range config.DeviceRequestParams {
+		params[k] = append([]string(nil), v...)
+	}
+	params.Set("client_id", config.ClientID)
+	params.Set("scope", strings.Join(config.Scopes, config.scopeSeparator()))
+	if len(config.Resources) > 0 {
+		params["resource"] = append([]string(nil), config.Resources...)
+	}
+	if config.Nonce != "" {
+		params.Set("nonce", config.Nonce)
+	}
+	if config.CodeVerifier != "" {
+		params.Set("code_challenge", pkceChallenge(config.CodeVerifier))
+		params.Set("code_challenge_method", pkceMethodS256)
+	}
+
+	req, err := newRequest(ctx, config.DeviceEndpoint.CodeURL, params, config.RequestEncoding)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := config.do(client, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer closeBody(resp)
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf(
-			"request for device code authorisation returned status %v (%v)",
-			resp.StatusCode, http.StatusText(resp.StatusCode))
+		return nil, resp.Header, newDeviceCodeError(resp)
 	}
 
 	// Unmarshal response
+	body, err := readJSON(resp, config.maxResponseSize())
+	if err != nil {
+		return nil, resp.Header, err
+	}
 	var dcr DeviceCode
-	dec := json.NewDecoder(resp.Body)
-	if err := dec.Decode(&dcr); err != nil {
-		return nil, err
+	if err := json.Unmarshal(body, &dcr); err != nil {
+		return nil, resp.Header, err
+	}
+	if err := dcr.validate(); err != nil {
+		return nil, resp.Header, err
 	}
+	if dcr.ExpiresIn > 0 {
+		dcr.Expiry = config.clock().Now().Add(time.Duration(dcr.ExpiresIn) * time.Second)
 
-	return &dcr, nil
+		// The user cannot realistically authorize the app in less time
+		// than a couple of polls.
+		lifetime, interval := time.Duration(dcr.ExpiresIn)*time.Second, config.pollInterval(&dcr)
+		if lifetime < 2*interval && config.OnWarning != nil {
+			config.OnWarning(fmt.Errorf("%w: expires in %v with a poll interval of %v",
+				ErrShortExpiry, lifetime, interval))
+		}
+	}
+
+	return &dcr, resp.Header, nil
 }
 
 // WaitForDeviceAuthorization polls the token URL waiting for the user to
-// authorize the app. Upon authorization, it returns the new token. If
-// authorization fails then an error is returned. If that failure was due to a
-// user explicitly denying access, the error is ErrAccessDenied.
+// authorize the app. Upon authorization, it returns the new token; every field
+// of the token response, such as id_token or scope, is available via the
+// token's Extra method. If authorization fails then an error is returned. If
+// that failure was due to a user explicitly denying access, the error is an
+// *AuthorizationError carrying the provider's description which matches
+// ErrAccessDenied with errors.Is. If the device code expires before the user
+// acts, the error is ErrDeviceCodeExpired.
+//
+// Neither config nor code is modified, even when the provider asks for polling
+// to slow down, so both may be shared by concurrent calls as long as the
+// caller does not modify them either.
 func WaitForDeviceAuthorization(client *http.Client, config *Config, code *DeviceCode) (*oauth2.Token, error) {
+	return WaitForDeviceAuthorizationContext(context.Background(), client, config, code)
+}
+
+// WaitForDeviceAuthorizationContext is like WaitForDeviceAuthorization but
+// attaches ctx to every poll of the token URL. If ctx is cancelled or its
+// deadline passes while waiting, ctx.Err() is returned.
+func WaitForDeviceAuthorizationContext(ctx context.Context, client *http.Client, config *Config, code *DeviceCode) (*oauth2.Token, error) {
+	return (&Client{HTTPClient: client, Config: config}).WaitForAuthorization(ctx, code)
+}
+
+// WaitForDeviceAuthorizationUntil is like WaitForDeviceAuthorizationContext but
+// gives up at deadline, even if the device code has not yet expired and the
+// provider still reports the authorization as pending. The error is then
+// context.DeadlineExceeded.
+func WaitForDeviceAuthorizationUntil(ctx context.Context, client *http.Client, config *Config, code *DeviceCode, deadline time.Time) (*oauth2.Token, error) {
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	return WaitForDeviceAuthorizationContext(ctx, client, config, code)
+}
+
+// WaitForDeviceAuthorizationDone is like WaitForDeviceAuthorizationContext but
+// also gives up when done is closed, for applications signalling shutdown on a
+// channel rather than by cancelling a context. The error is then
+// ErrInterrupted.
+func WaitForDeviceAuthorizationDone(ctx context.Context, client *http.Client, config *Config, code *DeviceCode, done <-chan struct{}) (*oauth2.Token, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		select {
+		case <-done:
+			cancel(ErrInterrupted)
+		case <-ctx.Done():
+		}
+	}()
+
+	tok, err := WaitForDeviceAuthorizationContext(ctx, client, config, code)
+	if err != nil && context.Cause(ctx) == ErrInterrupted {
+		return nil, ErrInterrupted
+	}
+	return tok, err
+}
+
+func waitForDeviceAuthorization(ctx context.Context, client *http.Client, config *Config, code *DeviceCode) (*oauth2.Token, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	clk := config.clock()
+	deadline := code.Expiry
+	if deadline.IsZero() && code.ExpiresIn > 0 {
+		deadline = clk.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	}
+
+	params := url.Values{
+		"device_code": {code.DeviceCode},
+		"grant_type":  {config.deviceGrantType()}}
+	if config.SendScopeOnTokenRequest {
+		params.Set("scope", strings.Join(config.Scopes, config.scopeSeparator()))
+	}
+	if config.CodeVerifier != "" {
+		params.Set("code_verifier", config.CodeVerifier)
+	}
+	if len(config.Resources) > 0 {
+		params["resource"] = append([]string(nil), config.Resources...)
+	}
+
+	// The interval is tracked locally, never in code, so that concurrent
+	// waits on the same DeviceCode do not race.
+	interval := config.pollInterval(code)
+
+	if config.InitialPollDelay > 0 {
+		if err := config.sleep(ctx, config.InitialPollDelay, deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	attempt, retries, backoff := 0, 0, initialRetryBackoff
 	for {
-		resp, err := client.PostForm(config.Endpoint.TokenURL,
-			url.Values{
-				"client_secret": {config.ClientSecret},
-				"client_id":     {config.ClientID},
-				"device_code":   {code.DeviceCode},
-				"grant_type":    {deviceGrantType}})
+		// This also stops a resumed code which has already expired before
+		// its first poll.
+		if !deadline.IsZero() && clk.Now().After(deadline) {
+			return nil, ErrDeviceCodeExpired
+		}
+
+		req, err := config.newTokenRequest(ctx, params)
 		if err != nil {
 			return nil, err
 		}
-		if resp.StatusCode == http.StatusPreconditionRequired {
-			time.Sleep(time.Duration(code.Interval) * time.Second)
+
+		reqStart := clk.Now()
+		resp, err := config.do(client, req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if retries < config.maxTransportRetries() && isTransient(err) {
+				retries++
+				if err := config.sleep(ctx, backoff, deadline); err != nil {
+					return nil, err
+				}
+				backoff *= 2
+				continue
+			}
+			return nil, err
+		}
+		retries, backoff = 0, initialRetryBackoff
+		attempt++
+		if config.OnPoll != nil {
+			config.OnPoll(PollInfo{
+				Attempt:    attempt,
+				StatusCode: resp.StatusCode,
+				Duration:   clk.Now().Sub(reqStart),
+			})
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			wait := retryAfter(resp.Header, clk.Now(), config.minPollInterval(), config.jitter(interval))
+			closeBody(resp)
+			if config.MaxPollAttempts > 0 && attempt >= config.MaxPollAttempts {
+				return nil, ErrMaxPollAttempts
+			}
+			config.progress(Progress{Attempt: attempt, Interval: wait, SlowDown: true, Remaining: remaining(clk, deadline)})
+			if err := config.sleep(ctx, wait, deadline); err != nil {
+				return nil, err
+			}
 			continue
 
-		} else if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
-			return nil, fmt.Errorf("HTTP error %v (%v) when polling for OAuth token",
-				resp.StatusCode, http.StatusText(resp.StatusCode))
+		} else if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized &&
+			!config.isPendingStatus(resp.StatusCode) {
+			// RFC 6749 section 5.2 error responses use 400, or 401 for
+			// invalid_client; anything else is not an OAuth2 response.
+			err := newHTTPError(resp)
+			closeBody(resp)
+			return nil, fmt.Errorf("%w when polling for OAuth token", err)
 		}
 
 		// Unmarshal response, checking for errors
+		body, err := readJSON(resp, config.maxResponseSize())
+		closeBody(resp)
 		var token tokenOrError
-		dec := json.NewDecoder(resp.Body)
-		if err := dec.Decode(&token); err != nil {
+		if err == nil {
+			err = json.Unmarshal(body, &token)
+		}
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusBadRequest, http.StatusUnauthorized:
+		default:
+			// Other pending statuses, such as 428, need not carry an
+			// OAuth2 error body.
+			if err != nil || token.Error == "" {
+				token, err = tokenOrError{Error: "authorization_pending"}, nil
+			}
+		}
+		if err != nil {
 			return nil, err
 		}
 
-		if resp.StatusCode != http.StatusBadRequest {
-			if token.Expiry.IsZero() && token.ExpiresIn != 0 {
-				token.Expiry = time.Now().Add(time.Duration(token.ExpiresIn * int64(time.Second)))
+		// Adopt a new interval sent with the response. It replaces, rather
+		// than being doubled by, a slow_down.
+		newInterval := token.Error != "" && token.Interval > 0 && config.PollInterval == 0
+		if newInterval {
+			interval = config.clampInterval(time.Duration(token.Interval) * time.Second)
+		}
+
+		// Some providers rotate the device code, sending the one to use for
+		// the next poll with each error. code itself is left unchanged.
+		if token.Error != "" && token.DeviceCode != "" {
+			params.Set("device_code", token.DeviceCode)
+		}
+
+		// Error codes are handled alike whatever the status, as some
+		// providers, such as Salesforce, send authorization_pending with 200
+		// OK. The interval then still defaults and doubles as usual.
+		if token.Error != "" && config.ErrorMapper != nil {
+			if err := config.ErrorMapper(token.Error, string(token.ErrorDescription)); err != nil {
+				return nil, err
 			}
 		}
 
+		wait := config.jitter(interval)
+		slowDown := false
 		switch token.Error {
 		case "":
-
-			return token.Token, nil
+			// The expiry is only computed once the response is known to
+			// hold a token rather than an error.
+			tok, err := token.oauth2Token(body, clk.Now())
+			if err != nil {
+				return nil, err
+			}
+			if config.AccessTokenAudience != "" {
+				if err := CheckAccessTokenAudience(tok, config.AccessTokenAudience); err != nil {
+					return nil, err
+				}
+			}
+			return tok, nil
 		case "authorization_pending":
 
 		case "slow_down":
 
-			code.Interval *= 2
+			if !newInterval {
+				interval = config.slowDown(interval)
+			}
+			wait = retryAfter(resp.Header, clk.Now(), interval, config.jitter(interval))
+			slowDown = true
 		case "access_denied":
 
-			return nil, ErrAccessDenied
+			return nil, &AuthorizationError{
+				Code:        token.Error,
+				Description: string(token.ErrorDescription),
+				URI:         token.ErrorURI,
+			}
+		case "expired_token":
+
+			return nil, ErrDeviceCodeExpired
 		default:
 
-			return nil, fmt.Errorf("authorization failed: %v", token.Error)
+			if config.ErrorMapper != nil {
+				break
+			}
+			return nil, &AuthorizationError{
+				Code:        token.Error,
+				Description: string(token.ErrorDescription),
+				URI:         token.ErrorURI,
+			}
+		}
+
+		if config.MaxPollAttempts > 0 && attempt >= config.MaxPollAttempts {
+			return nil, ErrMaxPollAttempts
+		}
+		config.progress(Progress{Attempt: attempt, Interval: wait, SlowDown: slowDown, Remaining: remaining(clk, deadline)})
+		if err := config.sleep(ctx, wait, deadline); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// remaining returns the time left until deadline, or zero if it is zero.
+func remaining(clk clock, deadline time.Time) time.Duration {
+	if deadline.IsZero() {
+		return 0
+	}
+	return deadline.Sub(clk.Now())
+}
+
+// scopeSeparator returns the separator used to join c.Scopes.
+func (c *Config) scopeSeparator() string {
+	if c.ScopeSeparator != "" {
+		return c.ScopeSeparator
+	}
+	return " "
+}
+
+// deviceGrantType returns the grant type sent when polling.
+func (c *Config) deviceGrantType() string {
+	if c.DeviceGrantType != "" {
+		return c.DeviceGrantType
+	}
+	return deviceGrantType
+}
+
+// isPendingStatus reports whether status is one of c.PendingStatusCodes.
+func (c *Config) isPendingStatus(status int) bool {
+	codes := c.PendingStatusCodes
+	if codes == nil {
+		codes = DefaultPendingStatusCodes
+	}
+	for _, code := range codes {
+		if code == status {
+			return true
+		}
+	}
+	return false
+}
+
+// sleep waits for d, or until ctx is done, calling c.OnCountdown if set with
+// the time left until deadline.
+func (c *Config) sleep(ctx context.Context, d time.Duration, deadline time.Time) error {
+	clk := c.clock()
+	if c.OnCountdown == nil || deadline.IsZero() {
+		return clk.Sleep(ctx, d)
+	}
+
+	tick := c.CountdownInterval
+	if tick <= 0 {
+		tick = DefaultCountdownInterval
+	}
+	for d > 0 {
+		remaining := deadline.Sub(clk.Now())
+		if remaining < 0 {
+			remaining = 0
+		}
+		c.OnCountdown(remaining)
+
+		step := tick
+		if step > d {
+			step = d
+		}
+		if err := clk.Sleep(ctx, step); err != nil {
+			return err
 		}
+		d -= step
+	}
+	return nil
+}
+
+// onError calls c.OnError, if set, with err.
+func (c *Config) onError(err error) {
+	if c != nil && c.OnError != nil {
+		c.OnError(err)
+	}
+}
+
+// progress calls c.OnProgress, if set, with p.
+func (c *Config) progress(p Progress) {
+	if c.OnProgress != nil {
+		c.OnProgress(p)
+	}
+}
+
+// pollInterval returns the initial interval between polls for code.
+func (c *Config) pollInterval(code *DeviceCode) time.Duration {
+	if c.PollInterval != 0 {
+		return c.PollInterval
+	}
+	interval := time.Duration(code.Interval) * time.Second
+	if interval == 0 {
+		interval = DefaultPollInterval
+	}
+	return c.clampInterval(interval)
+}
+
+// clampInterval returns interval, an interval requested by the provider,
+// raised to at least c.MinPollInterval.
+func (c *Config) clampInterval(interval time.Duration) time.Duration {
+	if min := c.minPollInterval(); interval < min {
+		return min
+	}
+	return interval
+}
+
+// minPollInterval returns c.MinPollInterval, or DefaultMinPollInterval if it
+// is not set.
+func (c *Config) minPollInterval() time.Duration {
+	if c.MinPollInterval == 0 {
+		return DefaultMinPollInterval
+	}
+	return c.MinPollInterval
+}
+
+// slowDown returns the poll interval to use after a slow_down error, doubling
+// interval up to c.MaxPollInterval.
+func (c *Config) slowDown(interval time.Duration) time.Duration {
+	max := c.MaxPollInterval
+	if max == 0 {
+		max = DefaultMaxPollInterval
+	}
+
+	if doubled := interval * 2; doubled <= max {
+		return doubled
+	} else if interval < max {
+		return max
+	}
+	return interval
+}
+
+// maxResponseSize returns the limit on response bodies.
+func (c *Config) maxResponseSize() int64 {
+	if c.MaxResponseSize > 0 {
+		return c.MaxResponseSize
+	}
+	return DefaultMaxResponseSize
+}
+
+// maxTransportRetries returns the number of retries allowed after transient
+// network errors.
+func (c *Config) maxTransportRetries() int {
+	switch {
+	case c.MaxTransportRetries < 0:
+		return 0
+	case c.MaxTransportRetries == 0:
+		return DefaultMaxTransportRetries
+	}
+	return c.MaxTransportRetries
+}
+
+// isTransient reports whether err, returned by http.Client.Do, is a network
+// error which may succeed if retried.
+func isTransient(err error) bool {
+	// *url.Error is itself a net.Error, so look at what it wraps.
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		err = uerr.Err
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var nerr net.Error
+	return errors.As(err, &nerr)
+}
 
-		time.Sleep(time.Duration(code.Interval) * time.Second)
+// jitter returns d randomised as configured by c.PollJitter.
+func (c *Config) jitter(d time.Duration) time.Duration {
+	if !c.PollJitter {
+		return d
+	}
+	frac := c.PollJitterFraction
+	if frac == 0 {
+		frac = DefaultPollJitterFraction
+	}
+	return d + time.Duration((rand.Float64()*2-1)*frac*float64(d))
+}
+
+// retryAfter returns the delay requested by the Retry-After header in h, which
+// may be given either in seconds or as an HTTP date relative to now, raised
+// to at least min so that a zero or past value cannot make polling spin. If
+// the header is absent or malformed, fallback is returned.
+func retryAfter(h http.Header, now time.Time, min, fallback time.Duration) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return fallback
+	}
+	var d time.Duration
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil && secs >= 0 {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = t.Sub(now)
+	} else {
+		return fallback
+	}
+	if d < min {
+		return min
+	}
+	return d
+}
+
+// do sends req using client, asking for a JSON response and applying
+// c.UserAgent, c.RequestDecorator and c.RequestTimeout.
+func (c *Config) do(client *http.Client, req *http.Request) (*http.Response, error) {
+	// Strict providers may otherwise answer with HTML or XML.
+	req.Header.Set("Accept", "application/json")
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	if c.RequestDecorator != nil {
+		if err := c.RequestDecorator(req); err != nil {
+			return nil, fmt.Errorf("decorating request: %w", err)
+		}
+	}
+
+	if c.RequestTimeout <= 0 {
+		resp, err := client.Do(req)
+		c.logResponse(req, resp, err)
+		return resp, err
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), c.RequestTimeout)
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		c.logResponse(req, nil, err)
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	c.logResponse(req, resp, nil)
+	return resp, nil
+}
+
+// A cancelBody is a response body which cancels the request's context once it
+// is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// newTokenRequest returns a POST request to the token URL with params and the
+// client credentials, sent as configured by c.AuthStyle, c.TLSClientAuth and
+// c.ClientAssertion.
+func (c *Config) newTokenRequest(ctx context.Context, params url.Values) (*http.Request, error) {
+	data := url.Values{}
+	for k, v := range c.TokenRequestParams {
+		data[k] = append([]string(nil), v...)
+	}
+	for k, v := range params {
+		data[k] = v
+	}
+	data.Set("client_id", c.ClientID)
+	secretAuth := !c.TLSClientAuth && c.ClientAssertion == nil
+	if c.ClientAssertion != nil {
+		assertion, err := c.ClientAssertion(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("creating client assertion: %w", err)
+		}
+		data.Set("client_assertion_type", clientAssertionType)
+		data.Set("client_assertion", assertion)
+	}
+	// Public clients have no secret, and some providers reject an empty one.
+	if secretAuth && c.AuthStyle != oauth2.AuthStyleInHeader && c.ClientSecret != "" {
+		data.Set("client_secret", c.ClientSecret)
+	}
+
+	req, err := newFormRequest(ctx, c.Endpoint.TokenURL, data)
+	if err != nil {
+		return nil, err
+	}
+	if secretAuth && c.AuthStyle == oauth2.AuthStyleInHeader {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+	return req, nil
+}
+
+// newRequest returns a POST request to endpoint with data encoded in its body
+// as selected by enc.
+func newRequest(ctx context.Context, endpoint string, data url.Values, enc RequestEncoding) (*http.Request, error) {
+	if enc == RequestEncodingJSON {
+		return newJSONRequest(ctx, endpoint, data)
+	}
+	return newFormRequest(ctx, endpoint, data)
+}
+
+// newFormRequest returns a POST request to endpoint with data as its
+// form-encoded body.
+func newFormRequest(ctx context.Context, endpoint string, data url.Values) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint,
+		strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+// newJSONRequest returns a POST request to endpoint with data as a JSON object
+// in its body. Parameters with a single value are encoded as strings and those
+// with several values as arrays of strings.
+func newJSONRequest(ctx context.Context, endpoint string, data url.Values) (*http.Request, error) {
+	obj := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		if len(v) == 1 {
+			obj[k] = v[0]
+		} else {
+			obj[k] = v
+		}
+	}
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint,
+		bytes.NewReader(body))
+	if err != nil {
+		return nil, err
 	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
 }
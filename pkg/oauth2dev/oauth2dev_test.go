@@ -0,0 +1,1665 @@
+package oauth2dev_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev/oauth2devtest"
+	"golang.org/x/oauth2"
+)
+
+const testDeviceResponse = `{"device_code":"test-device-code","user_code":"WDJB-MJHT",` +
+	`"verification_uri":"https://example.com/device","expires_in":600,"interval":5}`
+
+// A response is a canned response from a test server.
+type response struct {
+	status int
+	body   string
+	header http.Header
+}
+
+var (
+	pending  = response{http.StatusBadRequest, `{"error":"authorization_pending"}`, nil}
+	slowDown = response{http.StatusBadRequest, `{"error":"slow_down"}`, nil}
+	token    = response{http.StatusOK, `{"access_token":"test-access-token","token_type":"Bearer","expires_in":3600}`, nil}
+)
+
+// A testServer is a device flow provider whose device endpoint returns
+// testDeviceResponse and whose token endpoint sends its responses in turn,
+// repeating the last. It records the requests it receives.
+type testServer struct {
+	*httptest.Server
+	config *oauth2dev.Config
+	clock  *oauth2dev.FakeClock
+
+	mu        sync.Mutex
+	responses []response
+	device    []*http.Request
+	polls     []*http.Request
+}
+
+// newTestServer starts a testServer, closed when the test finishes, with a
+// Config for it using a fake clock.
+func newTestServer(t *testing.T, responses ...response) *testServer {
+	s := &testServer{responses: responses}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		s.record(&s.device, r)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, testDeviceResponse)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		s.record(&s.polls, r)
+		s.mu.Lock()
+		resp := s.responses[0]
+		if len(s.responses) > 1 {
+			s.responses = s.responses[1:]
+		}
+		s.mu.Unlock()
+
+		for k, v := range resp.header {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(resp.status)
+		io.WriteString(w, resp.body)
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	s.config = &oauth2dev.Config{
+		Config: &oauth2.Config{
+			ClientID: "test-client",
+			Endpoint: oauth2.Endpoint{TokenURL: s.URL + "/token"},
+			Scopes:   []string{"openid", "email"},
+		},
+		DeviceEndpoint: oauth2dev.DeviceEndpoint{CodeURL: s.URL + "/device"},
+	}
+	s.clock = oauth2dev.NewFakeClock()
+	oauth2dev.SetClock(s.config, s.clock)
+	return s
+}
+
+func (s *testServer) record(reqs *[]*http.Request, r *http.Request) {
+	r.ParseForm()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*reqs = append(*reqs, r)
+}
+
+// poll returns the i'th request to the token endpoint.
+func (s *testServer) poll(i int) *http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.polls[i]
+}
+
+// pollCount returns the number of requests to the token endpoint.
+func (s *testServer) pollCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.polls)
+}
+
+// wait waits for authorization of testCode.
+func (s *testServer) wait() (*oauth2.Token, error) {
+	return oauth2dev.WaitForDeviceAuthorization(s.Client(), s.config, testCode())
+}
+
+// testCode returns the device code issued by a testServer.
+func testCode() *oauth2dev.DeviceCode {
+	return &oauth2dev.DeviceCode{
+		DeviceCode:      "test-device-code",
+		UserCode:        "WDJB-MJHT",
+		VerificationURL: "https://example.com/device",
+		ExpiresIn:       600,
+		Interval:        5,
+	}
+}
+
+// newFixture starts an oauth2devtest.Server, closed when the test finishes,
+// with a Config for it using a fake clock.
+func newFixture(t *testing.T, opts oauth2devtest.Options) (*oauth2devtest.Server, *oauth2dev.Config, *oauth2dev.FakeClock) {
+	s := oauth2devtest.NewServer(opts)
+	t.Cleanup(s.Close)
+	config := s.Config("test-client", "openid", "email")
+	clk := oauth2dev.NewFakeClock()
+	oauth2dev.SetClock(config, clk)
+	return s, config, clk
+}
+
+func TestRequestDeviceCode(t *testing.T) {
+	s, config, clk := newFixture(t, oauth2devtest.Options{Interval: 5, ExpiresIn: 600})
+
+	code, err := oauth2dev.RequestDeviceCode(s.Client(), config)
+	if err != nil {
+		t.Fatalf("RequestDeviceCode: %v", err)
+	}
+	want := &oauth2dev.DeviceCode{
+		DeviceCode:              "test-device-code",
+		UserCode:                "WDJB-MJHT",
+		VerificationURL:         s.URL + "/verify",
+		VerificationURLComplete: s.URL + "/verify?user_code=WDJB-MJHT",
+		ExpiresIn:               600,
+		Interval:                5,
+		Expiry:                  clk.Now().Add(600 * time.Second),
+	}
+	if !reflect.DeepEqual(code, want) {
+		t.Errorf("RequestDeviceCode = %+v, want %+v", code, want)
+	}
+
+	form := s.DeviceRequest()
+	if got := form.Get("client_id"); got != "test-client" {
+		t.Errorf("client_id = %q, want %q", got, "test-client")
+	}
+	if got := form.Get("scope"); got != "openid email" {
+		t.Errorf("scope = %q, want %q", got, "openid email")
+	}
+}
+
+func TestRequestDeviceCodeContext(t *testing.T) {
+	s, config, _ := newFixture(t, oauth2devtest.Options{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := oauth2dev.RequestDeviceCodeContext(ctx, s.Client(), config); !errors.Is(err, context.Canceled) {
+		t.Errorf("RequestDeviceCodeContext error = %v, want context.Canceled", err)
+	}
+}
+
+func TestDeviceCodeUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want oauth2dev.DeviceCode
+	}{{
+		name: "standard",
+		json: `{"device_code":"d","user_code":"u","verification_uri":"https://a","verification_uri_complete":"https://a?u","expires_in":600,"interval":5}`,
+		want: oauth2dev.DeviceCode{DeviceCode: "d", UserCode: "u", VerificationURL: "https://a", VerificationURLComplete: "https://a?u", ExpiresIn: 600, Interval: 5},
+	}, {
+		name: "Google",
+		json: `{"device_code":"d","user_code":"u","verification_url":"https://a","verification_url_complete":"https://a?u","expires_in":1800}`,
+		want: oauth2dev.DeviceCode{DeviceCode: "d", UserCode: "u", VerificationURL: "https://a", VerificationURLComplete: "https://a?u", ExpiresIn: 1800},
+	}, {
+		name: "standard preferred",
+		json: `{"verification_uri":"https://standard","verification_url":"https://google"}`,
+		want: oauth2dev.DeviceCode{VerificationURL: "https://standard"},
+	}, {
+		name: "string numbers",
+		json: `{"expires_in":"600","interval":" 5 "}`,
+		want: oauth2dev.DeviceCode{ExpiresIn: 600, Interval: 5},
+	}, {
+		name: "null numbers",
+		json: `{"expires_in":null,"interval":null}`,
+		want: oauth2dev.DeviceCode{},
+	}, {
+		name: "message",
+		json: `{"message":"To sign in, visit https://a and enter u."}`,
+		want: oauth2dev.DeviceCode{Message: "To sign in, visit https://a and enter u."},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got oauth2dev.DeviceCode
+			if err := json.Unmarshal([]byte(tt.json), &got); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	var code oauth2dev.DeviceCode
+	if err := json.Unmarshal([]byte(`{"expires_in":"soon"}`), &code); err == nil {
+		t.Error("Unmarshal of a non-numeric expires_in succeeded")
+	}
+}
+
+// deviceServer starts a server, closed when the test finishes, answering
+// every request with status and body, and returns a Config for it.
+func deviceServer(t *testing.T, status int, body string) (*httptest.Server, *oauth2dev.Config) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &oauth2dev.Config{
+		Config: &oauth2.Config{
+			ClientID: "test-client",
+			Endpoint: oauth2.Endpoint{TokenURL: srv.URL + "/token"},
+		},
+		DeviceEndpoint: oauth2dev.DeviceEndpoint{CodeURL: srv.URL + "/device"},
+	}
+}
+
+func TestRequestDeviceCodeMissingFields(t *testing.T) {
+	srv, config := deviceServer(t, http.StatusOK, `{"user_code":"u"}`)
+	_, err := oauth2dev.RequestDeviceCode(srv.Client(), config)
+	if err == nil || !strings.Contains(err.Error(), "device_code, verification_uri") {
+		t.Errorf("RequestDeviceCode error = %v, want missing device_code and verification_uri", err)
+	}
+}
+
+func TestRequestDeviceCodeWithoutComplete(t *testing.T) {
+	srv, config := deviceServer(t, http.StatusOK, `{"device_code":"d","user_code":"u","verification_uri":"https://a"}`)
+	code, err := oauth2dev.RequestDeviceCode(srv.Client(), config)
+	if err != nil {
+		t.Fatalf("RequestDeviceCode: %v", err)
+	}
+	if code.VerificationURLComplete != "" || code.UserInstructions() != "Visit https://a and enter the code u" {
+		t.Errorf("RequestDeviceCode = %+v with instructions %q", code, code.UserInstructions())
+	}
+}
+
+func TestRequestDeviceCodeError(t *testing.T) {
+	srv, config := deviceServer(t, http.StatusUnauthorized, `{"error":"invalid_client","error_description":"Unknown &quot;client&quot;"}`)
+
+	_, err := oauth2dev.RequestDeviceCode(srv.Client(), config)
+	var dcErr *oauth2dev.DeviceCodeError
+	if !errors.As(err, &dcErr) {
+		t.Fatalf("RequestDeviceCode error = %v, want a *DeviceCodeError", err)
+	}
+	if dcErr.StatusCode != http.StatusUnauthorized || dcErr.Code != "invalid_client" || dcErr.Description != `Unknown "client"` {
+		t.Errorf("DeviceCodeError = %+v", dcErr)
+	}
+	var httpErr *oauth2dev.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusUnauthorized || !strings.Contains(httpErr.Body, "invalid_client") {
+		t.Errorf("RequestDeviceCode error wraps HTTPError %+v", httpErr)
+	}
+}
+
+func TestRequestDeviceCodeMalformed(t *testing.T) {
+	srv, config := deviceServer(t, http.StatusOK, `<html>Gateway timeout</html>`)
+
+	_, err := oauth2dev.RequestDeviceCode(srv.Client(), config)
+	var mErr *oauth2dev.MalformedResponseError
+	if !errors.As(err, &mErr) {
+		t.Fatalf("RequestDeviceCode error = %v, want a *MalformedResponseError", err)
+	}
+	if mErr.StatusCode != http.StatusOK || mErr.ContentType != "application/json" || mErr.Snippet != "<html>Gateway timeout</html>" {
+		t.Errorf("MalformedResponseError = %+v", mErr)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *oauth2dev.Config {
+		return &oauth2dev.Config{
+			Config: &oauth2.Config{
+				ClientID: "test-client",
+				Endpoint: oauth2.Endpoint{TokenURL: "https://example.com/token"},
+			},
+			DeviceEndpoint: oauth2dev.DeviceEndpoint{CodeURL: "https://example.com/device"},
+		}
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("Validate of a valid Config: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		config func() *oauth2dev.Config
+	}{
+		{"nil", func() *oauth2dev.Config { return nil }},
+		{"no oauth2.Config", func() *oauth2dev.Config { c := valid(); c.Config = nil; return c }},
+		{"no client ID", func() *oauth2dev.Config { c := valid(); c.ClientID = ""; return c }},
+		{"no device code URL", func() *oauth2dev.Config { c := valid(); c.DeviceEndpoint.CodeURL = ""; return c }},
+		{"relative device code URL", func() *oauth2dev.Config { c := valid(); c.DeviceEndpoint.CodeURL = "/device"; return c }},
+		{"no token URL", func() *oauth2dev.Config { c := valid(); c.Endpoint.TokenURL = ""; return c }},
+	}
+	// No request may be made with an invalid Config.
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		t.Errorf("request made to %v", r.URL)
+		return nil, errors.New("unexpected request")
+	})}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config().Validate(); err == nil {
+				t.Error("Validate succeeded")
+			}
+			if _, err := oauth2dev.RequestDeviceCode(client, tt.config()); err == nil {
+				t.Error("RequestDeviceCode succeeded")
+			}
+			if _, err := oauth2dev.WaitForDeviceAuthorization(client, tt.config(), testCode()); err == nil {
+				t.Error("WaitForDeviceAuthorization succeeded")
+			}
+		})
+	}
+}
+
+// A roundTripFunc is an http.RoundTripper implemented by a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestClone(t *testing.T) {
+	c := &oauth2dev.Config{
+		Config:              &oauth2.Config{ClientID: "test-client", Scopes: []string{"openid"}},
+		DeviceRequestParams: url.Values{"audience": {"api"}},
+		TokenRequestParams:  url.Values{"audience": {"api"}},
+		Resources:           []string{"https://a.example.com"},
+		PendingStatusCodes:  []int{},
+	}
+	clone := c.Clone()
+	if !reflect.DeepEqual(clone, c) {
+		t.Fatalf("Clone = %+v, want %+v", clone, c)
+	}
+	if clone.PendingStatusCodes == nil {
+		t.Error("Clone made an empty PendingStatusCodes nil")
+	}
+
+	clone.ClientID = "other"
+	clone.Scopes[0] = "email"
+	clone.DeviceRequestParams["audience"][0] = "other"
+	clone.TokenRequestParams.Set("audience", "other")
+	clone.Resources[0] = "https://b.example.com"
+	if c.ClientID != "test-client" || c.Scopes[0] != "openid" || c.DeviceRequestParams.Get("audience") != "api" ||
+		c.TokenRequestParams.Get("audience") != "api" || c.Resources[0] != "https://a.example.com" {
+		t.Errorf("modifying the clone changed the original: %+v", c)
+	}
+
+	if (*oauth2dev.Config)(nil).Clone() != nil {
+		t.Error("Clone of nil is not nil")
+	}
+}
+
+func TestShortExpiryWarning(t *testing.T) {
+	for _, tt := range []struct {
+		expiresIn int64
+		warn      bool
+	}{{5, true}, {9, true}, {10, false}, {600, false}} {
+		s, config, _ := newFixture(t, oauth2devtest.Options{Interval: 5, ExpiresIn: tt.expiresIn})
+		var warnings []error
+		config.OnWarning = func(err error) { warnings = append(warnings, err) }
+
+		if _, err := oauth2dev.RequestDeviceCode(s.Client(), config); err != nil {
+			t.Fatalf("RequestDeviceCode: %v", err)
+		}
+		if tt.warn && (len(warnings) != 1 || !errors.Is(warnings[0], oauth2dev.ErrShortExpiry)) {
+			t.Errorf("expires_in %v: warnings = %v, want ErrShortExpiry", tt.expiresIn, warnings)
+		} else if !tt.warn && len(warnings) != 0 {
+			t.Errorf("expires_in %v: warnings = %v, want none", tt.expiresIn, warnings)
+		}
+	}
+}
+
+func TestRequestDeviceCodeDetailed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "test-request")
+		if r.FormValue("client_id") != "test-client" {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error":"invalid_client"}`)
+			return
+		}
+		io.WriteString(w, testDeviceResponse)
+	}))
+	defer srv.Close()
+	config := &oauth2dev.Config{
+		Config: &oauth2.Config{
+			ClientID: "test-client",
+			Endpoint: oauth2.Endpoint{TokenURL: srv.URL + "/token"},
+		},
+		DeviceEndpoint: oauth2dev.DeviceEndpoint{CodeURL: srv.URL + "/device"},
+	}
+
+	code, header, err := oauth2dev.RequestDeviceCodeDetailed(context.Background(), srv.Client(), config)
+	if err != nil || code.DeviceCode != "test-device-code" {
+		t.Fatalf("RequestDeviceCodeDetailed = %+v, %v", code, err)
+	}
+	if got := header.Get("X-Request-Id"); got != "test-request" {
+		t.Errorf("X-Request-Id = %q, want %q", got, "test-request")
+	}
+
+	config.ClientID = "other"
+	_, header, err = oauth2dev.RequestDeviceCodeDetailed(context.Background(), srv.Client(), config)
+	if err == nil || header.Get("X-Request-Id") != "test-request" {
+		t.Errorf("RequestDeviceCodeDetailed of an error = %v, %v, want the headers", header, err)
+	}
+}
+
+func TestUserInstructions(t *testing.T) {
+	tests := []struct {
+		code oauth2dev.DeviceCode
+		want string
+	}{
+		{oauth2dev.DeviceCode{UserCode: "u", VerificationURL: "https://a"}, "Visit https://a and enter the code u"},
+		{oauth2dev.DeviceCode{UserCode: "u", VerificationURL: "https://a", VerificationURLComplete: "https://a?u"}, "Visit https://a?u and check that it shows the code u"},
+		{oauth2dev.DeviceCode{UserCode: "u", VerificationURL: "https://a", Message: "Go to https://a"}, "Go to https://a"},
+	}
+	for _, tt := range tests {
+		if got := tt.code.UserInstructions(); got != tt.want {
+			t.Errorf("UserInstructions of %+v = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestUserCodeFormatting(t *testing.T) {
+	tests := []struct {
+		code, plain, formatted string
+	}{
+		{"WDJBMJHT", "WDJBMJHT", "WDJB-MJHT"},
+		{"WDJB-MJHT", "WDJBMJHT", "WDJB-MJHT"},
+		{"WDJ BMJ", "WDJBMJ", "WDJ BMJ"},
+		{"ABCDEFGHI", "ABCDEFGHI", "ABC-DEF-GHI"},
+		{"ABCDEFG", "ABCDEFG", "ABCDEFG"},
+		{"ABCD", "ABCD", "ABCD"},
+	}
+	for _, tt := range tests {
+		code := oauth2dev.DeviceCode{UserCode: tt.code}
+		if got := code.PlainUserCode(); got != tt.plain {
+			t.Errorf("PlainUserCode of %q = %q, want %q", tt.code, got, tt.plain)
+		}
+		if got := code.FormattedUserCode(); got != tt.formatted {
+			t.Errorf("FormattedUserCode of %q = %q, want %q", tt.code, got, tt.formatted)
+		}
+	}
+}
+
+func TestTerminalHyperlink(t *testing.T) {
+	code := oauth2dev.DeviceCode{VerificationURL: "https://a"}
+	if got := code.TerminalHyperlink(); got != "https://a" {
+		t.Errorf("TerminalHyperlink without a complete URL = %q, want the plain URL", got)
+	}
+
+	code.VerificationURLComplete = "https://a?u\x1b"
+	want := "\x1b]8;;https://a?u\x1b\\https://a?u\x1b]8;;\x1b\\"
+	if got := code.TerminalHyperlink(); got != want {
+		t.Errorf("TerminalHyperlink = %q, want %q", got, want)
+	}
+}
+
+func TestVerificationURLParsed(t *testing.T) {
+	code := oauth2dev.DeviceCode{
+		VerificationURL:         "https://example.com/device",
+		VerificationURLComplete: "https://example.com/device?user_code=WDJB-MJHT",
+	}
+	u, err := code.VerificationURLParsed()
+	if err != nil || u.Host != "example.com" {
+		t.Errorf("VerificationURLParsed = %v, %v", u, err)
+	}
+	u, err = code.VerificationURLCompleteParsed()
+	if err != nil || u.Query().Get("user_code") != "WDJB-MJHT" {
+		t.Errorf("VerificationURLCompleteParsed = %v, %v", u, err)
+	}
+
+	for _, bad := range []string{"", "/device", "javascript:alert(1)", "https://"} {
+		code := oauth2dev.DeviceCode{VerificationURL: bad, VerificationURLComplete: bad}
+		if _, err := code.VerificationURLParsed(); err == nil {
+			t.Errorf("VerificationURLParsed of %q succeeded", bad)
+		}
+		if _, err := code.VerificationURLCompleteParsed(); err == nil {
+			t.Errorf("VerificationURLCompleteParsed of %q succeeded", bad)
+		}
+	}
+}
+
+func TestDeviceCodeResume(t *testing.T) {
+	s, config, clk := newFixture(t, oauth2devtest.Options{ExpiresIn: 600})
+	code, err := oauth2dev.RequestDeviceCode(s.Client(), config)
+	if err != nil {
+		t.Fatalf("RequestDeviceCode: %v", err)
+	}
+
+	saved, err := json.Marshal(code)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var resumed oauth2dev.DeviceCode
+	if err := json.Unmarshal(saved, &resumed); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !resumed.Expiry.Equal(code.Expiry) {
+		t.Errorf("resumed Expiry = %v, want %v", resumed.Expiry, code.Expiry)
+	}
+	if _, err := oauth2dev.WaitForDeviceAuthorization(s.Client(), config, &resumed); err != nil {
+		t.Errorf("WaitForDeviceAuthorization of the resumed code: %v", err)
+	}
+
+	// Once its expiry has passed, no poll is made.
+	clk.Sleep(context.Background(), time.Hour)
+	polls := s.Polls()
+	if _, err := oauth2dev.WaitForDeviceAuthorization(s.Client(), config, &resumed); err != oauth2dev.ErrDeviceCodeExpired {
+		t.Errorf("WaitForDeviceAuthorization of an expired code error = %v, want ErrDeviceCodeExpired", err)
+	}
+	if s.Polls() != polls {
+		t.Error("expired code was polled")
+	}
+}
+
+func TestDeviceCodeZeroExpiry(t *testing.T) {
+	saved, err := json.Marshal(&oauth2dev.DeviceCode{DeviceCode: "d"})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var code oauth2dev.DeviceCode
+	if err := json.Unmarshal(saved, &code); err != nil {
+		t.Fatalf("Unmarshal of %s: %v", saved, err)
+	}
+	if !code.Expiry.IsZero() || code.Expired() {
+		t.Errorf("zero Expiry resumed as %v, expired %v", code.Expiry, code.Expired())
+	}
+}
+
+func TestWaitForDeviceAuthorization(t *testing.T) {
+	s, config, clk := newFixture(t, oauth2devtest.Options{
+		SlowDown:    1,
+		Pending:     2,
+		AccessToken: "access",
+		TokenExtra:  map[string]interface{}{"id_token": "id", "scope": "openid email"},
+	})
+	code, err := oauth2dev.RequestDeviceCode(s.Client(), config)
+	if err != nil {
+		t.Fatalf("RequestDeviceCode: %v", err)
+	}
+
+	tok, err := oauth2dev.WaitForDeviceAuthorization(s.Client(), config, code)
+	if err != nil {
+		t.Fatalf("WaitForDeviceAuthorization: %v", err)
+	}
+	if tok.AccessToken != "access" || tok.TokenType != "Bearer" {
+		t.Errorf("token = %+v", tok)
+	}
+	if want := clk.Now().Add(time.Hour); !tok.Expiry.Equal(want) {
+		t.Errorf("token Expiry = %v, want %v", tok.Expiry, want)
+	}
+	if tok.Extra("id_token") != "id" || tok.Extra("scope") != "openid email" {
+		t.Errorf("token extras id_token = %v, scope = %v", tok.Extra("id_token"), tok.Extra("scope"))
+	}
+	if got := s.Polls(); got != 4 {
+		t.Errorf("polls = %v, want 4", got)
+	}
+	// The default interval is doubled by the slow_down.
+	if got, want := clk.Sleeps(), []time.Duration{10 * time.Second, 10 * time.Second, 10 * time.Second}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sleeps = %v, want %v", got, want)
+	}
+
+	want := url.Values{
+		"client_id":   {"test-client"},
+		"device_code": {"test-device-code"},
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+	}
+	if got := s.TokenRequest(); !reflect.DeepEqual(got, want) {
+		t.Errorf("token request = %v, want %v", got, want)
+	}
+}
+
+func TestWaitForDeviceAuthorizationContext(t *testing.T) {
+	s := newTestServer(t, token)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := oauth2dev.WaitForDeviceAuthorizationContext(ctx, s.Client(), s.config, testCode()); !errors.Is(err, context.Canceled) {
+		t.Errorf("WaitForDeviceAuthorizationContext error = %v, want context.Canceled", err)
+	}
+}
+
+func TestWaitClientAuthentication(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*oauth2dev.Config)
+		check     func(*testing.T, *http.Request)
+	}{{
+		name:      "client_secret_post",
+		configure: func(c *oauth2dev.Config) { c.ClientSecret = "test-secret" },
+		check: func(t *testing.T, r *http.Request) {
+			if got := r.PostForm.Get("client_secret"); got != "test-secret" {
+				t.Errorf("client_secret = %q, want %q", got, "test-secret")
+			}
+			if _, _, ok := r.BasicAuth(); ok {
+				t.Error("Basic authentication used")
+			}
+		},
+	}, {
+		name: "client_secret_basic",
+		configure: func(c *oauth2dev.Config) {
+			c.ClientSecret = "test secret"
+			c.AuthStyle = oauth2.AuthStyleInHeader
+		},
+		check: func(t *testing.T, r *http.Request) {
+			user, pass, ok := r.BasicAuth()
+			if !ok || user != "test-client" || pass != "test+secret" {
+				t.Errorf("Basic authentication = %q, %q, %v", user, pass, ok)
+			}
+			if _, ok := r.PostForm["client_secret"]; ok {
+				t.Error("client_secret sent in the body")
+			}
+		},
+	}, {
+		name:      "none",
+		configure: func(c *oauth2dev.Config) {},
+		check: func(t *testing.T, r *http.Request) {
+			if _, ok := r.PostForm["client_secret"]; ok {
+				t.Error("empty client_secret sent")
+			}
+			if _, _, ok := r.BasicAuth(); ok {
+				t.Error("Basic authentication used")
+			}
+			if got := r.PostForm.Get("client_id"); got != "test-client" {
+				t.Errorf("client_id = %q, want %q", got, "test-client")
+			}
+		},
+	}, {
+		name: "tls_client_auth",
+		configure: func(c *oauth2dev.Config) {
+			c.ClientSecret = "test-secret"
+			c.AuthStyle = oauth2.AuthStyleInHeader
+			c.TLSClientAuth = true
+		},
+		check: func(t *testing.T, r *http.Request) {
+			if _, ok := r.PostForm["client_secret"]; ok {
+				t.Error("client_secret sent")
+			}
+			if _, _, ok := r.BasicAuth(); ok {
+				t.Error("Basic authentication used")
+			}
+		},
+	}, {
+		name: "private_key_jwt",
+		configure: func(c *oauth2dev.Config) {
+			c.ClientSecret = "test-secret"
+			c.ClientAssertion = func(ctx context.Context) (string, error) { return "test-assertion", nil }
+		},
+		check: func(t *testing.T, r *http.Request) {
+			if got := r.PostForm.Get("client_assertion"); got != "test-assertion" {
+				t.Errorf("client_assertion = %q, want %q", got, "test-assertion")
+			}
+			if got := r.PostForm.Get("client_assertion_type"); got != "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" {
+				t.Errorf("client_assertion_type = %q", got)
+			}
+			if _, ok := r.PostForm["client_secret"]; ok {
+				t.Error("client_secret sent")
+			}
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, token)
+			tt.configure(s.config)
+			if _, err := s.wait(); err != nil {
+				t.Fatalf("WaitForDeviceAuthorization: %v", err)
+			}
+			tt.check(t, s.poll(0))
+		})
+	}
+}
+
+func TestWaitClientAssertionError(t *testing.T) {
+	s := newTestServer(t, token)
+	errSign := errors.New("no key")
+	s.config.ClientAssertion = func(ctx context.Context) (string, error) { return "", errSign }
+
+	if _, err := s.wait(); !errors.Is(err, errSign) {
+		t.Errorf("error = %v, want %v", err, errSign)
+	}
+	if s.pollCount() != 0 {
+		t.Error("token URL polled without an assertion")
+	}
+}
+
+func TestRequestHeaders(t *testing.T) {
+	s := newTestServer(t, token)
+	s.config.UserAgent = "test-agent/1.0"
+	s.config.RequestDecorator = func(r *http.Request) error {
+		r.Header.Set("X-Correlation-Id", "test-id")
+		return nil
+	}
+
+	if _, err := oauth2dev.RequestDeviceCode(s.Client(), s.config); err != nil {
+		t.Fatalf("RequestDeviceCode: %v", err)
+	}
+	if _, err := s.wait(); err != nil {
+		t.Fatalf("WaitForDeviceAuthorization: %v", err)
+	}
+	for _, r := range []*http.Request{s.device[0], s.poll(0)} {
+		if got := r.Header.Get("User-Agent"); got != "test-agent/1.0" {
+			t.Errorf("%v User-Agent = %q, want %q", r.URL.Path, got, "test-agent/1.0")
+		}
+		if got := r.Header.Get("Accept"); got != "application/json" {
+			t.Errorf("%v Accept = %q, want application/json", r.URL.Path, got)
+		}
+		if got := r.Header.Get("X-Correlation-Id"); got != "test-id" {
+			t.Errorf("%v X-Correlation-Id = %q, want %q", r.URL.Path, got, "test-id")
+		}
+	}
+
+	errDecorate := errors.New("no proof")
+	s.config.RequestDecorator = func(r *http.Request) error { return errDecorate }
+	polls := s.pollCount()
+	if _, err := s.wait(); !errors.Is(err, errDecorate) {
+		t.Errorf("error = %v, want %v", err, errDecorate)
+	}
+	if s.pollCount() != polls {
+		t.Error("request sent despite the decorator's error")
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	config := &oauth2dev.Config{
+		Config: &oauth2.Config{
+			ClientID: "test-client",
+			Endpoint: oauth2.Endpoint{TokenURL: srv.URL + "/token"},
+		},
+		DeviceEndpoint:      oauth2dev.DeviceEndpoint{CodeURL: srv.URL + "/device"},
+		RequestTimeout:      20 * time.Millisecond,
+		MaxTransportRetries: -1,
+	}
+
+	start := time.Now()
+	if _, err := oauth2dev.RequestDeviceCode(srv.Client(), config); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RequestDeviceCode error = %v, want context.DeadlineExceeded", err)
+	}
+	if _, err := oauth2dev.WaitForDeviceAuthorization(srv.Client(), config, testCode()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitForDeviceAuthorization error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("requests took %v", elapsed)
+	}
+}
+
+// flakyTransport fails its first fails requests with a network error.
+type flakyTransport struct {
+	mu    sync.Mutex
+	fails int
+	tries int
+}
+
+func (t *flakyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.tries++
+	fail := t.tries <= t.fails
+	t.mu.Unlock()
+	if fail {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestWaitTransientErrors(t *testing.T) {
+	s := newTestServer(t, pending, token)
+	rt := &flakyTransport{fails: 2}
+
+	if _, err := oauth2dev.WaitForDeviceAuthorization(&http.Client{Transport: rt}, s.config, testCode()); err != nil {
+		t.Fatalf("WaitForDeviceAuthorization: %v", err)
+	}
+	// Backs off 1s then 2s before polling as usual.
+	if got, want := s.clock.Sleeps(), []time.Duration{time.Second, 2 * time.Second, 5 * time.Second}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sleeps = %v, want %v", got, want)
+	}
+
+	rt = &flakyTransport{fails: 100}
+	_, err := oauth2dev.WaitForDeviceAuthorization(&http.Client{Transport: rt}, s.config, testCode())
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		t.Errorf("error = %v, want the network error", err)
+	}
+	if want := 1 + oauth2dev.DefaultMaxTransportRetries; rt.tries != want {
+		t.Errorf("tries = %v, want %v", rt.tries, want)
+	}
+}
+
+func TestWaitHooks(t *testing.T) {
+	s := newTestServer(t, pending, slowDown, token)
+	var (
+		progress []oauth2dev.Progress
+		polls    []oauth2dev.PollInfo
+		received *oauth2.Token
+		errs     []error
+	)
+	s.config.OnProgress = func(p oauth2dev.Progress) { progress = append(progress, p) }
+	s.config.OnPoll = func(p oauth2dev.PollInfo) { polls = append(polls, p) }
+	s.config.OnTokenReceived = func(tok *oauth2.Token, elapsed time.Duration) {
+		received = tok
+		if elapsed != 15*time.Second {
+			t.Errorf("OnTokenReceived elapsed = %v, want 15s", elapsed)
+		}
+	}
+	s.config.OnError = func(err error) { errs = append(errs, err) }
+
+	tok, err := s.wait()
+	if err != nil {
+		t.Fatalf("WaitForDeviceAuthorization: %v", err)
+	}
+	wantProgress := []oauth2dev.Progress{
+		{Attempt: 1, Interval: 5 * time.Second, Remaining: 600 * time.Second},
+		{Attempt: 2, Interval: 10 * time.Second, SlowDown: true, Remaining: 595 * time.Second},
+	}
+	if !reflect.DeepEqual(progress, wantProgress) {
+		t.Errorf("progress = %+v, want %+v", progress, wantProgress)
+	}
+	if len(polls) != 3 || polls[0].Attempt != 1 || polls[0].StatusCode != http.StatusBadRequest || polls[2].StatusCode != http.StatusOK {
+		t.Errorf("polls = %+v", polls)
+	}
+	if received != tok {
+		t.Error("OnTokenReceived not called with the token")
+	}
+	if len(errs) != 0 {
+		t.Errorf("OnError called with %v", errs)
+	}
+
+	var requested *oauth2dev.DeviceCode
+	s.config.OnDeviceCodeRequested = func(code *oauth2dev.DeviceCode, elapsed time.Duration) { requested = code }
+	code, err := oauth2dev.RequestDeviceCode(s.Client(), s.config)
+	if err != nil || requested != code {
+		t.Errorf("OnDeviceCodeRequested called with %v, want %v", requested, code)
+	}
+
+	s = newTestServer(t, response{http.StatusBadRequest, `{"error":"access_denied"}`, nil})
+	s.config.OnError = func(err error) { errs = append(errs, err) }
+	_, err = s.wait()
+	if len(errs) != 1 || errs[0] != err {
+		t.Errorf("OnError called with %v, want %v", errs, err)
+	}
+}
+
+func TestWaitContextCancelled(t *testing.T) {
+	s := newTestServer(t, pending)
+	// The real clock, so that cancellation interrupts the sleep.
+	oauth2dev.SetClock(s.config, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := oauth2dev.WaitForDeviceAuthorizationContext(ctx, s.Client(), s.config, testCode()); err != context.Canceled {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("returned after %v, want about 20ms", elapsed)
+	}
+}
+
+func TestWaitUntil(t *testing.T) {
+	s := newTestServer(t, pending)
+	config := s.config.Clone()
+	oauth2dev.SetClock(config, nil)
+	config.PollInterval = 10 * time.Millisecond
+
+	_, err := oauth2dev.WaitForDeviceAuthorizationUntil(context.Background(), s.Client(), config, testCode(), time.Now().Add(50*time.Millisecond))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestWaitDone(t *testing.T) {
+	s := newTestServer(t, pending)
+	config := s.config.Clone()
+	oauth2dev.SetClock(config, nil)
+
+	done := make(chan struct{})
+	time.AfterFunc(20*time.Millisecond, func() { close(done) })
+	start := time.Now()
+	if _, err := oauth2dev.WaitForDeviceAuthorizationDone(context.Background(), s.Client(), config, testCode(), done); err != oauth2dev.ErrInterrupted {
+		t.Errorf("error = %v, want ErrInterrupted", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("returned after %v, want about 20ms", elapsed)
+	}
+
+	// An open channel changes nothing.
+	s = newTestServer(t, token)
+	if _, err := oauth2dev.WaitForDeviceAuthorizationDone(context.Background(), s.Client(), s.config, testCode(), make(chan struct{})); err != nil {
+		t.Errorf("WaitForDeviceAuthorizationDone: %v", err)
+	}
+}
+
+func TestWaitConcurrent(t *testing.T) {
+	s := newTestServer(t, slowDown, slowDown, pending, pending, token)
+	code := testCode()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := oauth2dev.WaitForDeviceAuthorization(s.Client(), s.config, code); err != nil {
+				t.Errorf("WaitForDeviceAuthorization: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if !reflect.DeepEqual(code, testCode()) {
+		t.Errorf("DeviceCode modified to %+v", code)
+	}
+}
+
+func TestWaitResponses(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []response
+		polls     int
+		check     func(*testing.T, error)
+	}{{
+		name:      "400 authorization_pending",
+		responses: []response{pending, pending, token},
+		polls:     3,
+	}, {
+		name:      "400 slow_down",
+		responses: []response{slowDown, token},
+		polls:     2,
+	}, {
+		name:      "428 without a body",
+		responses: []response{{http.StatusPreconditionRequired, ``, nil}, token},
+		polls:     2,
+	}, {
+		name:      "428 authorization_pending",
+		responses: []response{{http.StatusPreconditionRequired, `{"error":"authorization_pending"}`, nil}, token},
+		polls:     2,
+	}, {
+		name:      "200 authorization_pending",
+		responses: []response{{http.StatusOK, `{"error":"authorization_pending"}`, nil}, token},
+		polls:     2,
+	}, {
+		name:      "200 access_denied",
+		responses: []response{{http.StatusOK, `{"error":"access_denied"}`, nil}},
+		polls:     1,
+		check: func(t *testing.T, err error) {
+			if !errors.Is(err, oauth2dev.ErrAccessDenied) {
+				t.Errorf("error = %v, want ErrAccessDenied", err)
+			}
+		},
+	}, {
+		name:      "200 token",
+		responses: []response{token},
+		polls:     1,
+	}, {
+		name:      "401 invalid_client",
+		responses: []response{{http.StatusUnauthorized, `{"error":"invalid_client"}`, nil}},
+		polls:     1,
+		check: func(t *testing.T, err error) {
+			var authErr *oauth2dev.AuthorizationError
+			if !errors.As(err, &authErr) || authErr.Code != "invalid_client" {
+				t.Errorf("error = %v, want an invalid_client *AuthorizationError", err)
+			}
+		},
+	}, {
+		name:      "500",
+		responses: []response{{http.StatusInternalServerError, `oops`, nil}},
+		polls:     1,
+		check: func(t *testing.T, err error) {
+			var httpErr *oauth2dev.HTTPError
+			if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusInternalServerError || httpErr.Body != "oops" {
+				t.Errorf("error = %v, want a 500 *HTTPError", err)
+			}
+		},
+	}, {
+		name:      "400 HTML",
+		responses: []response{{http.StatusBadRequest, `<html>Bad request</html>`, nil}},
+		polls:     1,
+		check: func(t *testing.T, err error) {
+			var mErr *oauth2dev.MalformedResponseError
+			if !errors.As(err, &mErr) || mErr.StatusCode != http.StatusBadRequest {
+				t.Errorf("error = %v, want a *MalformedResponseError", err)
+			}
+		},
+	}, {
+		name:      "200 without an access token",
+		responses: []response{{http.StatusOK, `{"token_type":"Bearer"}`, nil}},
+		polls:     1,
+		check: func(t *testing.T, err error) {
+			if err == nil || !strings.Contains(err.Error(), "no access_token") {
+				t.Errorf("error = %v, want no access_token", err)
+			}
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.responses...)
+			tok, err := s.wait()
+			if tt.check != nil {
+				tt.check(t, err)
+			} else if err != nil || tok.AccessToken != "test-access-token" {
+				t.Errorf("WaitForDeviceAuthorization = %+v, %v", tok, err)
+			}
+			if got := s.pollCount(); got != tt.polls {
+				t.Errorf("polls = %v, want %v", got, tt.polls)
+			}
+		})
+	}
+}
+
+func TestWaitPendingStatusCodes(t *testing.T) {
+	forbidden := response{http.StatusForbidden, `{"error":"authorization_pending"}`, nil}
+
+	s := newTestServer(t, forbidden, token)
+	s.config.PendingStatusCodes = []int{http.StatusBadRequest, http.StatusForbidden}
+	if _, err := s.wait(); err != nil {
+		t.Fatalf("WaitForDeviceAuthorization: %v", err)
+	}
+	if got := s.pollCount(); got != 2 {
+		t.Errorf("polls = %v, want 2", got)
+	}
+
+	s = newTestServer(t, forbidden, token)
+	var httpErr *oauth2dev.HTTPError
+	if _, err := s.wait(); !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusForbidden {
+		t.Errorf("error without a custom status = %v, want a 403 *HTTPError", err)
+	}
+}
+
+func TestWaitErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want error
+		auth *oauth2dev.AuthorizationError
+	}{{
+		name: "access_denied",
+		body: `{"error":"access_denied","error_description":"The user can&#39;t sign in","error_uri":"https://example.com/help"}`,
+		want: oauth2dev.ErrAccessDenied,
+		auth: &oauth2dev.AuthorizationError{Code: "access_denied", Description: "The user can't sign in", URI: "https://example.com/help"},
+	}, {
+		name: "expired_token",
+		body: `{"error":"expired_token"}`,
+		want: oauth2dev.ErrDeviceCodeExpired,
+	}, {
+		name: "unknown",
+		body: `{"error":"invalid_grant","error_description":"Bad &amp; wrong"}`,
+		auth: &oauth2dev.AuthorizationError{Code: "invalid_grant", Description: "Bad & wrong"},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, pending, response{http.StatusBadRequest, tt.body, nil})
+			_, err := s.wait()
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+			if tt.auth != nil {
+				var authErr *oauth2dev.AuthorizationError
+				if !errors.As(err, &authErr) || *authErr != *tt.auth {
+					t.Errorf("error = %#v, want %#v", err, tt.auth)
+				}
+				if !strings.Contains(err.Error(), tt.auth.Description) {
+					t.Errorf("error message %q does not contain %q", err, tt.auth.Description)
+				}
+			}
+		})
+	}
+
+	if err := (&oauth2dev.AuthorizationError{Code: "invalid_client"}); errors.Is(err, oauth2dev.ErrAccessDenied) {
+		t.Error("invalid_client matches ErrAccessDenied")
+	}
+}
+
+func TestWaitErrorMapper(t *testing.T) {
+	errLocked := errors.New("account locked")
+	errThrottled := errors.New("throttled")
+	mapper := func(code, description string) error {
+		switch code {
+		case "access_denied":
+			if description == "locked" {
+				return errLocked
+			}
+		case "slow_down":
+			return errThrottled
+		}
+		return nil
+	}
+	tests := []struct {
+		name      string
+		responses []response
+		want      error
+		polls     int
+	}{{
+		name:      "remapped access_denied",
+		responses: []response{{http.StatusBadRequest, `{"error":"access_denied","error_description":"locked"}`, nil}},
+		want:      errLocked,
+		polls:     1,
+	}, {
+		name:      "access_denied",
+		responses: []response{{http.StatusBadRequest, `{"error":"access_denied"}`, nil}},
+		want:      oauth2dev.ErrAccessDenied,
+		polls:     1,
+	}, {
+		name:      "remapped slow_down",
+		responses: []response{pending, slowDown},
+		want:      errThrottled,
+		polls:     2,
+	}, {
+		name:      "unknown code keeps polling",
+		responses: []response{{http.StatusBadRequest, `{"error":"mfa_pending"}`, nil}, token},
+		polls:     2,
+	}, {
+		name:      "not called for a token",
+		responses: []response{token},
+		polls:     1,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.responses...)
+			s.config.ErrorMapper = mapper
+			if _, err := s.wait(); !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+			if got := s.pollCount(); got != tt.polls {
+				t.Errorf("polls = %v, want %v", got, tt.polls)
+			}
+		})
+	}
+}
+
+func TestWaitIntervals(t *testing.T) {
+	tests := []struct {
+		name       string
+		interval   int64
+		configure  func(*oauth2dev.Config)
+		responses  []response
+		wantSleeps []time.Duration
+	}{{
+		name:       "provider interval",
+		interval:   3,
+		responses:  []response{pending, pending, token},
+		wantSleeps: []time.Duration{3 * time.Second, 3 * time.Second},
+	}, {
+		name:       "default interval",
+		responses:  []response{pending, token},
+		wantSleeps: []time.Duration{oauth2dev.DefaultPollInterval},
+	}, {
+		name:       "PollInterval",
+		interval:   3,
+		configure:  func(c *oauth2dev.Config) { c.PollInterval = 100 * time.Millisecond },
+		responses:  []response{pending, token},
+		wantSleeps: []time.Duration{100 * time.Millisecond},
+	}, {
+		name:       "MinPollInterval",
+		interval:   1,
+		configure:  func(c *oauth2dev.Config) { c.MinPollInterval = 4 * time.Second },
+		responses:  []response{pending, {http.StatusBadRequest, `{"error":"authorization_pending","interval":2}`, nil}, token},
+		wantSleeps: []time.Duration{4 * time.Second, 4 * time.Second},
+	}, {
+		name:       "negative interval",
+		interval:   -5,
+		responses:  []response{pending, token},
+		wantSleeps: []time.Duration{oauth2dev.DefaultMinPollInterval},
+	}, {
+		name:       "slow_down",
+		interval:   5,
+		responses:  []response{slowDown, pending, slowDown, token},
+		wantSleeps: []time.Duration{10 * time.Second, 10 * time.Second, 20 * time.Second},
+	}, {
+		name:       "MaxPollInterval",
+		interval:   5,
+		configure:  func(c *oauth2dev.Config) { c.MaxPollInterval = 15 * time.Second },
+		responses:  []response{slowDown, slowDown, slowDown, token},
+		wantSleeps: []time.Duration{10 * time.Second, 15 * time.Second, 15 * time.Second},
+	}, {
+		name:       "interval above MaxPollInterval",
+		interval:   90,
+		responses:  []response{slowDown, token},
+		wantSleeps: []time.Duration{90 * time.Second},
+	}, {
+		name:       "interval update",
+		interval:   5,
+		responses:  []response{{http.StatusBadRequest, `{"error":"authorization_pending","interval":"7"}`, nil}, pending, token},
+		wantSleeps: []time.Duration{7 * time.Second, 7 * time.Second},
+	}, {
+		name:       "interval update with slow_down",
+		interval:   5,
+		responses:  []response{{http.StatusBadRequest, `{"error":"slow_down","interval":8}`, nil}, token},
+		wantSleeps: []time.Duration{8 * time.Second},
+	}, {
+		// Salesforce sends errors with 200 OK and no interval.
+		name: "200 errors without an interval",
+		responses: []response{
+			{http.StatusOK, `{"error":"authorization_pending"}`, nil},
+			{http.StatusOK, `{"error":"slow_down"}`, nil},
+			token,
+		},
+		wantSleeps: []time.Duration{oauth2dev.DefaultPollInterval, 2 * oauth2dev.DefaultPollInterval},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.responses...)
+			if tt.configure != nil {
+				tt.configure(s.config)
+			}
+			code := testCode()
+			code.Interval = tt.interval
+			if _, err := oauth2dev.WaitForDeviceAuthorization(s.Client(), s.config, code); err != nil {
+				t.Fatalf("WaitForDeviceAuthorization: %v", err)
+			}
+			if got := s.clock.Sleeps(); !reflect.DeepEqual(got, tt.wantSleeps) {
+				t.Errorf("sleeps = %v, want %v", got, tt.wantSleeps)
+			}
+		})
+	}
+}
+
+func TestWaitRetryAfter(t *testing.T) {
+	retryAfter := func(status int, body, value string) response {
+		return response{status, body, http.Header{"Retry-After": {value}}}
+	}
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	tests := []struct {
+		name       string
+		responses  []response
+		wantSleeps []time.Duration
+	}{{
+		name:       "429 seconds",
+		responses:  []response{retryAfter(http.StatusTooManyRequests, ``, "30"), token},
+		wantSleeps: []time.Duration{30 * time.Second},
+	}, {
+		name:       "429 without Retry-After",
+		responses:  []response{{http.StatusTooManyRequests, ``, nil}, token},
+		wantSleeps: []time.Duration{5 * time.Second},
+	}, {
+		name:       "429 zero",
+		responses:  []response{retryAfter(http.StatusTooManyRequests, ``, "0"), token},
+		wantSleeps: []time.Duration{oauth2dev.DefaultMinPollInterval},
+	}, {
+		name:       "429 past date",
+		responses:  []response{retryAfter(http.StatusTooManyRequests, ``, past), token},
+		wantSleeps: []time.Duration{oauth2dev.DefaultMinPollInterval},
+	}, {
+		name:       "429 malformed",
+		responses:  []response{retryAfter(http.StatusTooManyRequests, ``, "soon"), token},
+		wantSleeps: []time.Duration{5 * time.Second},
+	}, {
+		name:       "slow_down seconds",
+		responses:  []response{retryAfter(http.StatusBadRequest, `{"error":"slow_down"}`, "30"), token},
+		wantSleeps: []time.Duration{30 * time.Second},
+	}, {
+		name:       "slow_down zero",
+		responses:  []response{retryAfter(http.StatusBadRequest, `{"error":"slow_down"}`, "0"), token},
+		wantSleeps: []time.Duration{10 * time.Second},
+	}, {
+		name:       "slow_down past date",
+		responses:  []response{retryAfter(http.StatusBadRequest, `{"error":"slow_down"}`, past), token},
+		wantSleeps: []time.Duration{10 * time.Second},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.responses...)
+			if _, err := s.wait(); err != nil {
+				t.Fatalf("WaitForDeviceAuthorization: %v", err)
+			}
+			if got := s.clock.Sleeps(); !reflect.DeepEqual(got, tt.wantSleeps) {
+				t.Errorf("sleeps = %v, want %v", got, tt.wantSleeps)
+			}
+		})
+	}
+
+	s := newTestServer(t, retryAfter(http.StatusTooManyRequests, ``, "0"), token)
+	s.config.MinPollInterval = 3 * time.Second
+	if _, err := s.wait(); err != nil {
+		t.Fatalf("WaitForDeviceAuthorization: %v", err)
+	}
+	if got, want := s.clock.Sleeps(), []time.Duration{3 * time.Second}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sleeps with MinPollInterval = %v, want %v", got, want)
+	}
+}
+
+func TestWaitJitter(t *testing.T) {
+	responses := make([]response, 50)
+	for i := range responses {
+		responses[i] = pending
+	}
+	s := newTestServer(t, append(responses, token)...)
+	s.config.PollJitter = true
+	s.config.PollJitterFraction = 0.5
+
+	if _, err := s.wait(); err != nil {
+		t.Fatalf("WaitForDeviceAuthorization: %v", err)
+	}
+	distinct := make(map[time.Duration]bool)
+	for _, d := range s.clock.Sleeps() {
+		if d < 2500*time.Millisecond || d > 7500*time.Millisecond {
+			t.Errorf("sleep of %v is outside 5s ± 50%%", d)
+		}
+		distinct[d] = true
+	}
+	if len(distinct) < 2 {
+		t.Errorf("sleeps %v are not randomised", s.clock.Sleeps())
+	}
+}
+
+func TestWaitExpiry(t *testing.T) {
+	s := newTestServer(t, pending)
+	code := testCode()
+	code.ExpiresIn = 12
+
+	if _, err := oauth2dev.WaitForDeviceAuthorization(s.Client(), s.config, code); err != oauth2dev.ErrDeviceCodeExpired {
+		t.Errorf("error = %v, want ErrDeviceCodeExpired", err)
+	}
+	// Polls at 0, 5 and 10 seconds; the expiry has passed by the fourth.
+	if got := s.pollCount(); got != 3 {
+		t.Errorf("polls = %v, want 3", got)
+	}
+	// An absolute Expiry, as from a resumed device code, takes precedence.
+	code = testCode()
+	code.Expiry = s.clock.Now().Add(-time.Second)
+	if _, err := oauth2dev.WaitForDeviceAuthorization(s.Client(), s.config, code); err != oauth2dev.ErrDeviceCodeExpired {
+		t.Errorf("error with a past Expiry = %v, want ErrDeviceCodeExpired", err)
+	}
+	if got := s.pollCount(); got != 3 {
+		t.Errorf("polls after a past Expiry = %v, want 3", got)
+	}
+}
+
+func TestWaitMaxPollAttempts(t *testing.T) {
+	s := newTestServer(t, pending)
+	s.config.MaxPollAttempts = 3
+
+	if _, err := s.wait(); err != oauth2dev.ErrMaxPollAttempts {
+		t.Errorf("error = %v, want ErrMaxPollAttempts", err)
+	}
+	if got := s.pollCount(); got != 3 {
+		t.Errorf("polls = %v, want 3", got)
+	}
+	if got := len(s.clock.Sleeps()); got != 2 {
+		t.Errorf("slept %v times after the last poll, want 2", got)
+	}
+}
+
+func TestWaitTokenExpiresIn(t *testing.T) {
+	for _, expiresIn := range []string{`3600`, `"3600"`} {
+		s := newTestServer(t, response{http.StatusOK, `{"access_token":"a","token_type":"Bearer","expires_in":` + expiresIn + `}`, nil})
+		tok, err := s.wait()
+		if err != nil {
+			t.Fatalf("WaitForDeviceAuthorization with expires_in %s: %v", expiresIn, err)
+		}
+		if want := s.clock.Now().Add(time.Hour); !tok.Expiry.Equal(want) {
+			t.Errorf("expires_in %s: token Expiry = %v, want %v", expiresIn, tok.Expiry, want)
+		}
+	}
+
+	s := newTestServer(t, response{http.StatusOK, `{"access_token":"a","expires_in":"soon"}`, nil})
+	if _, err := s.wait(); err == nil {
+		t.Error("WaitForDeviceAuthorization with a non-numeric expires_in succeeded")
+	}
+}
+
+func TestRequestDeviceCodeParams(t *testing.T) {
+	s, config, _ := newFixture(t, oauth2devtest.Options{})
+	config.DeviceRequestParams = url.Values{"audience": {"api"}, "client_id": {"other"}}
+	config.Resources = []string{"https://a.example.com", "https://b.example.com"}
+	config.Nonce = "test-nonce"
+	// The example verifier of RFC 7636 appendix B.
+	config.CodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+	if _, err := oauth2dev.RequestDeviceCode(s.Client(), config); err != nil {
+		t.Fatalf("RequestDeviceCode: %v", err)
+	}
+	want := url.Values{
+		"audience":              {"api"},
+		"client_id":             {"test-client"},
+		"scope":                 {"openid email"},
+		"resource":              {"https://a.example.com", "https://b.example.com"},
+		"nonce":                 {"test-nonce"},
+		"code_challenge":        {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+		"code_challenge_method": {"S256"},
+	}
+	if got := s.DeviceRequest(); !reflect.DeepEqual(got, want) {
+		t.Errorf("device request = %v, want %v", got, want)
+	}
+	if got := config.DeviceRequestParams.Get("client_id"); got != "other" {
+		t.Errorf("DeviceRequestParams modified: client_id = %q", got)
+	}
+}
+
+func TestRequestDeviceCodeJSON(t *testing.T) {
+	var contentType string
+	var body map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		json.NewDecoder(r.Body).Decode(&body)
+		io.WriteString(w, testDeviceResponse)
+	}))
+	defer srv.Close()
+
+	config := &oauth2dev.Config{
+		Config: &oauth2.Config{
+			ClientID: "test-client",
+			Endpoint: oauth2.Endpoint{TokenURL: srv.URL + "/token"},
+			Scopes:   []string{"openid"},
+		},
+		DeviceEndpoint:  oauth2dev.DeviceEndpoint{CodeURL: srv.URL + "/device"},
+		Resources:       []string{"https://a.example.com", "https://b.example.com"},
+		RequestEncoding: oauth2dev.RequestEncodingJSON,
+	}
+	if _, err := oauth2dev.RequestDeviceCode(srv.Client(), config); err != nil {
+		t.Fatalf("RequestDeviceCode: %v", err)
+	}
+	if contentType != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", contentType)
+	}
+	want := map[string]interface{}{
+		"client_id": "test-client",
+		"scope":     "openid",
+		"resource":  []interface{}{"https://a.example.com", "https://b.example.com"},
+	}
+	if !reflect.DeepEqual(body, want) {
+		t.Errorf("request body = %v, want %v", body, want)
+	}
+}
+
+func TestRequestDeviceCodeWithScopes(t *testing.T) {
+	s, config, _ := newFixture(t, oauth2devtest.Options{})
+
+	if _, err := oauth2dev.RequestDeviceCodeWithScopes(s.Client(), config, []string{"profile"}); err != nil {
+		t.Fatalf("RequestDeviceCodeWithScopes: %v", err)
+	}
+	if got := s.DeviceRequest().Get("scope"); got != "profile" {
+		t.Errorf("scope = %q, want %q", got, "profile")
+	}
+	if !reflect.DeepEqual(config.Scopes, []string{"openid", "email"}) {
+		t.Errorf("config.Scopes modified to %v", config.Scopes)
+	}
+}
+
+func TestScopeSeparator(t *testing.T) {
+	s, config, _ := newFixture(t, oauth2devtest.Options{})
+	config.ScopeSeparator = ","
+
+	if _, err := oauth2dev.RequestDeviceCode(s.Client(), config); err != nil {
+		t.Fatalf("RequestDeviceCode: %v", err)
+	}
+	if got := s.DeviceRequest().Get("scope"); got != "openid,email" {
+		t.Errorf("scope = %q, want %q", got, "openid,email")
+	}
+}
+
+func TestWaitPollParams(t *testing.T) {
+	s := newTestServer(t, pending, token)
+	s.config.ClientSecret = "test-secret"
+	s.config.CodeVerifier = "test-verifier"
+	s.config.Resources = []string{"https://a.example.com", "https://b.example.com"}
+	s.config.TokenRequestParams = url.Values{"audience": {"api"}, "grant_type": {"other"}}
+
+	if _, err := s.wait(); err != nil {
+		t.Fatalf("WaitForDeviceAuthorization: %v", err)
+	}
+	want := url.Values{
+		"audience":      {"api"},
+		"client_id":     {"test-client"},
+		"client_secret": {"test-secret"},
+		"code_verifier": {"test-verifier"},
+		"device_code":   {"test-device-code"},
+		"grant_type":    {"urn:ietf:params:oauth:grant-type:device_code"},
+		"resource":      {"https://a.example.com", "https://b.example.com"},
+	}
+	for i := 0; i < 2; i++ {
+		if got := s.poll(i).PostForm; !reflect.DeepEqual(got, want) {
+			t.Errorf("poll %v = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestResponseSizeLimit(t *testing.T) {
+	huge := `{"access_token":"` + strings.Repeat("a", 2<<20) + `","token_type":"Bearer"}`
+	s := newTestServer(t, response{http.StatusOK, huge, nil})
+
+	if _, err := s.wait(); !errors.Is(err, oauth2dev.ErrResponseTooLarge) {
+		t.Errorf("error = %v, want ErrResponseTooLarge", err)
+	}
+	s.config.MaxResponseSize = 4 << 20
+	if _, err := s.wait(); err != nil {
+		t.Errorf("WaitForDeviceAuthorization with a larger MaxResponseSize: %v", err)
+	}
+
+	s.config.MaxResponseSize = 16
+	if _, err := oauth2dev.RequestDeviceCode(s.Client(), s.config); !errors.Is(err, oauth2dev.ErrResponseTooLarge) {
+		t.Errorf("RequestDeviceCode error = %v, want ErrResponseTooLarge", err)
+	}
+}
+
+func TestWaitDeviceGrantType(t *testing.T) {
+	s := newTestServer(t, token)
+	s.config.DeviceGrantType = "http://oauth.net/grant_type/device/1.0"
+
+	if _, err := s.wait(); err != nil {
+		t.Fatalf("WaitForDeviceAuthorization: %v", err)
+	}
+	if got := s.poll(0).PostForm.Get("grant_type"); got != "http://oauth.net/grant_type/device/1.0" {
+		t.Errorf("grant_type = %q, want the configured one", got)
+	}
+}
+
+func TestWaitSendScopeOnTokenRequest(t *testing.T) {
+	for _, send := range []bool{false, true} {
+		s := newTestServer(t, token)
+		s.config.SendScopeOnTokenRequest = send
+		s.config.ScopeSeparator = ","
+
+		if _, err := s.wait(); err != nil {
+			t.Fatalf("WaitForDeviceAuthorization: %v", err)
+		}
+		scope, ok := s.poll(0).PostForm["scope"]
+		if send && (!ok || scope[0] != "openid,email") {
+			t.Errorf("SendScopeOnTokenRequest: scope = %q, want %q", scope, "openid,email")
+		} else if !send && ok {
+			t.Errorf("scope = %q, want none", scope)
+		}
+	}
+}
+
+func TestWaitDeviceCodeRotation(t *testing.T) {
+	s := newTestServer(t,
+		response{http.StatusBadRequest, `{"error":"authorization_pending","device_code":"second"}`, nil},
+		response{http.StatusBadRequest, `{"error":"slow_down","device_code":"third"}`, nil},
+		pending,
+		token)
+	code := testCode()
+
+	if _, err := oauth2dev.WaitForDeviceAuthorization(s.Client(), s.config, code); err != nil {
+		t.Fatalf("WaitForDeviceAuthorization: %v", err)
+	}
+	var got []string
+	for i := 0; i < s.pollCount(); i++ {
+		got = append(got, s.poll(i).PostForm.Get("device_code"))
+	}
+	if want := []string{"test-device-code", "second", "third", "third"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("device codes polled = %v, want %v", got, want)
+	}
+	if code.DeviceCode != "test-device-code" {
+		t.Errorf("DeviceCode changed to %q", code.DeviceCode)
+	}
+}
+
+func TestWaitInitialPollDelay(t *testing.T) {
+	s := newTestServer(t, token)
+	s.config.InitialPollDelay = 5 * time.Second
+	start := s.clock.Now()
+
+	var firstPoll time.Time
+	s.config.OnPoll = func(oauth2dev.PollInfo) { firstPoll = s.clock.Now() }
+	if _, err := s.wait(); err != nil {
+		t.Fatalf("WaitForDeviceAuthorization: %v", err)
+	}
+	if got := firstPoll.Sub(start); got != 5*time.Second {
+		t.Errorf("first poll after %v, want 5s", got)
+	}
+}
+
+// closeTracker is a transport which counts the response bodies it returns
+// which have not been closed.
+type closeTracker struct {
+	mu   sync.Mutex
+	open int
+}
+
+func (c *closeTracker) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.open++
+	c.mu.Unlock()
+	resp.Body = &trackedBody{ReadCloser: resp.Body, tracker: c}
+	return resp, nil
+}
+
+type trackedBody struct {
+	io.ReadCloser
+	tracker *closeTracker
+	once    sync.Once
+}
+
+func (b *trackedBody) Close() error {
+	b.once.Do(func() {
+		b.tracker.mu.Lock()
+		b.tracker.open--
+		b.tracker.mu.Unlock()
+	})
+	return b.ReadCloser.Close()
+}
+
+func TestResponseBodiesClosed(t *testing.T) {
+	s := newTestServer(t, pending, slowDown, response{http.StatusTooManyRequests, ``, nil},
+		response{http.StatusPreconditionRequired, ``, nil}, token)
+	tracker := &closeTracker{}
+	client := &http.Client{Transport: tracker}
+
+	if _, err := oauth2dev.RequestDeviceCode(client, s.config); err != nil {
+		t.Fatalf("RequestDeviceCode: %v", err)
+	}
+	if _, err := oauth2dev.WaitForDeviceAuthorization(client, s.config, testCode()); err != nil {
+		t.Fatalf("WaitForDeviceAuthorization: %v", err)
+	}
+	errSrv, config := deviceServer(t, http.StatusInternalServerError, `oops`)
+	oauth2dev.RequestDeviceCode(client, config)
+	oauth2dev.WaitForDeviceAuthorization(client, config, testCode())
+	errSrv.Close()
+
+	if tracker.open != 0 {
+		t.Errorf("%v response bodies left open", tracker.open)
+	}
+}
+
+func TestWaitCountdown(t *testing.T) {
+	s := newTestServer(t, pending, token)
+	var countdown []time.Duration
+	s.config.OnCountdown = func(remaining time.Duration) { countdown = append(countdown, remaining) }
+	s.config.CountdownInterval = 2 * time.Second
+
+	if _, err := s.wait(); err != nil {
+		t.Fatalf("WaitForDeviceAuthorization: %v", err)
+	}
+	want := []time.Duration{600 * time.Second, 598 * time.Second, 596 * time.Second}
+	if !reflect.DeepEqual(countdown, want) {
+		t.Errorf("countdown = %v, want %v", countdown, want)
+	}
+	// The 5s wait is made in steps of the countdown interval.
+	if got, want := s.clock.Sleeps(), []time.Duration{2 * time.Second, 2 * time.Second, time.Second}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sleeps = %v, want %v", got, want)
+	}
+}
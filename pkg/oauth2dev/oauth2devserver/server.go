@@ -0,0 +1,444 @@
+// Package oauth2devserver implements the provider side of the OAuth2 device
+// flow (RFC 8628): the device authorization and token endpoints, matching the
+// client in package oauth2dev. The application supplies the verification page
+// at which users sign in, calling Approve or Deny with the user code they
+// enter, and decides what token to issue.
+package oauth2devserver
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	deviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// DefaultCodeLifetime is how long device and user codes are valid when
+	// Provider.CodeLifetime is not set.
+	DefaultCodeLifetime = 10 * time.Minute
+
+	// DefaultInterval is the poll interval sent to clients when
+	// Provider.Interval is not set.
+	DefaultInterval = 5 * time.Second
+
+	// userCodeAlphabet holds the characters of user codes: consonants only,
+	// as RFC 8628 section 6.1 suggests, so that codes are easy to type and
+	// never spell words.
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+var (
+	// ErrUnknownUserCode is an error returned by Approve and Deny when the
+	// user code does not belong to a pending authorization, perhaps because
+	// it has expired.
+	ErrUnknownUserCode = errors.New("unknown or expired user code")
+)
+
+// A Grant is a pending or completed authorization of a device.
+type Grant struct {
+	ClientID string
+	Scopes   []string
+	UserCode string
+	Expiry   time.Time
+}
+
+// A Token is the token issued to a device once its authorization is approved.
+type Token struct {
+	AccessToken  string
+	TokenType    string // "Bearer" if empty
+	RefreshToken string
+	IDToken      string
+	Scope        string
+	ExpiresIn    time.Duration
+}
+
+// A Provider serves the device flow endpoints. Its exported fields must be set
+// before it is used and not changed afterwards. A Provider is safe for
+// concurrent use.
+type Provider struct {
+	// VerificationURL is the page at which users enter their user code. It
+	// is returned to clients as verification_uri.
+	VerificationURL string
+
+	// CodeLifetime is how long each device code is valid for. If zero,
+	// DefaultCodeLifetime is used.
+	CodeLifetime time.Duration
+
+	// Interval is the minimum interval between polls of the token endpoint.
+	// Clients polling faster are told to slow down. If zero, DefaultInterval
+	// is used.
+	Interval time.Duration
+
+	// ValidClient, if set, reports whether a client may use the device flow,
+	// and with which scopes. If nil, any client is accepted.
+	ValidClient func(clientID string, scopes []string) bool
+
+	// IssueToken is called when an approved device polls the token
+	// endpoint, and returns the token to issue for g. It is required; without
+	// it the token endpoint responds with server_error. If it fails, the
+	// device is sent server_error and the grant stays approved, so that a
+	// later poll can still receive a token.
+	IssueToken func(g Grant) (*Token, error)
+
+	mu     sync.Mutex
+	grants map[string]*grant // by device code
+	users  map[string]*grant // by normalised user code
+
+	// now, if set, replaces time.Now in tests.
+	now func() time.Time
+}
+
+type grantState int
+
+const (
+	statePending grantState = iota
+	stateApproved
+	stateIssuing // approved, with IssueToken running
+	stateDenied
+)
+
+type grant struct {
+	Grant
+	deviceCode string
+	state      grantState
+	lastPoll   time.Time
+}
+
+// Lookup returns the pending authorization with the given user code, so that
+// the verification page can show the user which client is asking for which
+// scopes before they approve it.
+func (p *Provider) Lookup(userCode string) (Grant, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	g := p.pending(userCode)
+	if g == nil {
+		return Grant{}, false
+	}
+	return g.Grant, true
+}
+
+// Approve marks the authorization with the given user code as approved, so
+// that the device's next poll of the token endpoint receives a token. The code
+// is compared ignoring case, spaces and hyphens.
+func (p *Provider) Approve(userCode string) error {
+	return p.complete(userCode, stateApproved)
+}
+
+// Deny marks the authorization with the given user code as denied, so that
+// the device's next poll of the token endpoint receives access_denied.
+func (p *Provider) Deny(userCode string) error {
+	return p.complete(userCode, stateDenied)
+}
+
+func (p *Provider) complete(userCode string, state grantState) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	g := p.pending(userCode)
+	if g == nil {
+		return ErrUnknownUserCode
+	}
+	g.state = state
+	// The user code has been used; only the device can now proceed.
+	delete(p.users, normaliseUserCode(userCode))
+	return nil
+}
+
+// pending returns the unexpired pending grant with the given user code. p.mu
+// must be held.
+func (p *Provider) pending(userCode string) *grant {
+	g := p.users[normaliseUserCode(userCode)]
+	if g == nil || g.state != statePending || !p.clock().Before(g.Expiry) {
+		return nil
+	}
+	return g
+}
+
+// DeviceHandler returns the handler for the device authorization endpoint.
+func (p *Provider) DeviceHandler() http.Handler {
+	return http.HandlerFunc(p.handleDevice)
+}
+
+// TokenHandler returns the handler for the token endpoint. It only supports
+// the device code grant.
+func (p *Provider) TokenHandler() http.Handler {
+	return http.HandlerFunc(p.handleToken)
+}
+
+func (p *Provider) handleDevice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	clientID := r.PostForm.Get("client_id")
+	scopes := strings.Fields(r.PostForm.Get("scope"))
+	if clientID == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	if p.ValidClient != nil && !p.ValidClient(clientID, scopes) {
+		writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	g, err := p.newGrant(clientID, scopes)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	lifetime := g.Expiry.Sub(p.clock())
+	resp := map[string]interface{}{
+		"device_code":      g.deviceCode,
+		"user_code":        g.UserCode,
+		"verification_uri": p.VerificationURL,
+		"expires_in":       int64(lifetime / time.Second),
+		"interval":         int64(p.interval() / time.Second),
+	}
+	if u, err := url.Parse(p.VerificationURL); err == nil {
+		q := u.Query()
+		q.Set("user_code", g.UserCode)
+		u.RawQuery = q.Encode()
+		resp["verification_uri_complete"] = u.String()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// newGrant creates and stores a pending grant with fresh codes.
+func (p *Provider) newGrant(clientID string, scopes []string) (*grant, error) {
+	deviceCode, err := randomDeviceCode()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.clock()
+	p.sweep(now)
+	if p.grants == nil {
+		p.grants = make(map[string]*grant)
+		p.users = make(map[string]*grant)
+	}
+
+	var userCode string
+	for {
+		userCode, err = randomUserCode()
+		if err != nil {
+			return nil, err
+		}
+		if p.users[normaliseUserCode(userCode)] == nil {
+			break
+		}
+	}
+
+	lifetime := p.CodeLifetime
+	if lifetime == 0 {
+		lifetime = DefaultCodeLifetime
+	}
+	g := &grant{
+		Grant: Grant{
+			ClientID: clientID,
+			Scopes:   scopes,
+			UserCode: userCode,
+			Expiry:   now.Add(lifetime),
+		},
+		deviceCode: deviceCode,
+	}
+	p.grants[deviceCode] = g
+	p.users[normaliseUserCode(userCode)] = g
+	return g, nil
+}
+
+// sweep removes expired grants. p.mu must be held.
+func (p *Provider) sweep(now time.Time) {
+	for _, g := range p.grants {
+		if !now.Before(g.Expiry) {
+			p.remove(g)
+		}
+	}
+}
+
+// remove removes g. p.mu must be held.
+func (p *Provider) remove(g *grant) {
+	delete(p.grants, g.deviceCode)
+	// A used user code may since have been issued again.
+	if key := normaliseUserCode(g.UserCode); p.users[key] == g {
+		delete(p.users, key)
+	}
+}
+
+func (p *Provider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	if r.PostForm.Get("grant_type") != deviceGrantType {
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+	if p.IssueToken == nil {
+		writeError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	g, code := p.poll(r.PostForm.Get("device_code"), r.PostForm.Get("client_id"))
+	if code != "" {
+		writeError(w, http.StatusBadRequest, code)
+		return
+	}
+
+	tok, err := p.IssueToken(g.Grant)
+	p.issued(g, err == nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	resp := map[string]interface{}{
+		"access_token": tok.AccessToken,
+		"token_type":   tok.TokenType,
+	}
+	if tok.TokenType == "" {
+		resp["token_type"] = "Bearer"
+	}
+	if tok.RefreshToken != "" {
+		resp["refresh_token"] = tok.RefreshToken
+	}
+	if tok.IDToken != "" {
+		resp["id_token"] = tok.IDToken
+	}
+	if tok.Scope != "" {
+		resp["scope"] = tok.Scope
+	}
+	if tok.ExpiresIn > 0 {
+		resp["expires_in"] = int64(tok.ExpiresIn / time.Second)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// poll records a poll for the given device code and returns its grant if it
+// has been approved, or else the OAuth2 error code to respond with. A denied
+// grant is removed. An approved one is held while its token is issued, during
+// which other polls are told to wait, and must be passed to issued.
+func (p *Provider) poll(deviceCode, clientID string) (*grant, string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	g := p.grants[deviceCode]
+	if g == nil || g.ClientID != clientID {
+		return nil, "invalid_grant"
+	}
+	now := p.clock()
+	if !now.Before(g.Expiry) {
+		p.remove(g)
+		return nil, "expired_token"
+	}
+
+	switch g.state {
+	case stateApproved:
+		g.state = stateIssuing
+		return g, ""
+	case stateDenied:
+		p.remove(g)
+		return nil, "access_denied"
+	}
+
+	tooSoon := !g.lastPoll.IsZero() && now.Sub(g.lastPoll) < p.interval()
+	g.lastPoll = now
+	if tooSoon {
+		return nil, "slow_down"
+	}
+	return nil, "authorization_pending"
+}
+
+// issued records whether a token was issued for g, as returned by poll. Once
+// one has been, g is removed, so that each device code yields at most one
+// token; otherwise g is approved again for the device's next poll.
+func (p *Provider) issued(g *grant, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ok {
+		p.remove(g)
+	} else {
+		g.state = stateApproved
+	}
+}
+
+func (p *Provider) interval() time.Duration {
+	if p.Interval != 0 {
+		return p.Interval
+	}
+	return DefaultInterval
+}
+
+func (p *Provider) clock() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+// randomDeviceCode returns a new unguessable device code.
+func randomDeviceCode() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// randomUserCode returns a new user code such as "WDJB-MJHT".
+func randomUserCode() (string, error) {
+	var b strings.Builder
+	size := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := 0; i < userCodeLength; i++ {
+		if i == userCodeLength/2 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(userCodeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// normaliseUserCode returns code in upper case without hyphens or spaces, as
+// users may type it either way.
+func normaliseUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, code)
+}
+
+// writeError writes an RFC 6749 error response with the given code.
+func writeError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,301 @@
+package oauth2devserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+	"golang.org/x/oauth2"
+)
+
+// newProvider returns a Provider issuing the access token "access" whose
+// clock only moves when the returned function advances it.
+func newProvider() (*Provider, func(time.Duration)) {
+	var mu sync.Mutex
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	p := &Provider{
+		VerificationURL: "https://example.com/device",
+		IssueToken: func(g Grant) (*Token, error) {
+			return &Token{AccessToken: "access", Scope: strings.Join(g.Scopes, " "), ExpiresIn: time.Hour}, nil
+		},
+		now: func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		},
+	}
+	return p, func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+}
+
+// post sends form to h and returns the response status and decoded body.
+func post(t *testing.T, h http.Handler, form url.Values) (int, map[string]interface{}) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	return w.Code, body
+}
+
+// authorize requests a device code for client "c" from p.
+func authorize(t *testing.T, p *Provider) (deviceCode, userCode string) {
+	t.Helper()
+	status, body := post(t, p.DeviceHandler(), url.Values{"client_id": {"c"}, "scope": {"openid email"}})
+	if status != http.StatusOK {
+		t.Fatalf("device request status = %v, body %v", status, body)
+	}
+	return body["device_code"].(string), body["user_code"].(string)
+}
+
+// poll polls p's token endpoint for deviceCode as client "c".
+func poll(t *testing.T, p *Provider, deviceCode string) (int, map[string]interface{}) {
+	t.Helper()
+	return post(t, p.TokenHandler(), url.Values{
+		"grant_type":  {deviceGrantType},
+		"device_code": {deviceCode},
+		"client_id":   {"c"},
+	})
+}
+
+func TestDeviceFlow(t *testing.T) {
+	p := &Provider{
+		VerificationURL: "https://example.com/device",
+		Interval:        time.Millisecond,
+		IssueToken: func(g Grant) (*Token, error) {
+			return &Token{AccessToken: "access", RefreshToken: "refresh", IDToken: "id", Scope: "openid", ExpiresIn: time.Hour}, nil
+		},
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/device", p.DeviceHandler())
+	mux.Handle("/token", p.TokenHandler())
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	config := &oauth2dev.Config{
+		Config: &oauth2.Config{
+			ClientID: "test-client",
+			Endpoint: oauth2.Endpoint{TokenURL: srv.URL + "/token"},
+			Scopes:   []string{"openid"},
+		},
+		DeviceEndpoint: oauth2dev.DeviceEndpoint{CodeURL: srv.URL + "/device"},
+		PollInterval:   time.Millisecond,
+	}
+	code, err := oauth2dev.RequestDeviceCode(srv.Client(), config)
+	if err != nil {
+		t.Fatalf("RequestDeviceCode: %v", err)
+	}
+	if code.VerificationURL != "https://example.com/device" || code.ExpiresIn < 599 || code.ExpiresIn > 600 ||
+		code.VerificationURLComplete != "https://example.com/device?user_code="+url.QueryEscape(code.UserCode) {
+		t.Errorf("device code = %+v", code)
+	}
+
+	g, ok := p.Lookup(code.UserCode)
+	if !ok || g.ClientID != "test-client" || len(g.Scopes) != 1 || g.Scopes[0] != "openid" {
+		t.Errorf("Lookup = %+v, %v", g, ok)
+	}
+	time.AfterFunc(20*time.Millisecond, func() { p.Approve(code.UserCode) })
+
+	tok, err := oauth2dev.WaitForDeviceAuthorization(srv.Client(), config, code)
+	if err != nil {
+		t.Fatalf("WaitForDeviceAuthorization: %v", err)
+	}
+	if tok.AccessToken != "access" || tok.TokenType != "Bearer" || tok.RefreshToken != "refresh" ||
+		tok.Extra("id_token") != "id" || tok.Extra("scope") != "openid" {
+		t.Errorf("token = %+v", tok)
+	}
+}
+
+func TestDeny(t *testing.T) {
+	p, _ := newProvider()
+	deviceCode, userCode := authorize(t, p)
+
+	if err := p.Deny(userCode); err != nil {
+		t.Fatalf("Deny: %v", err)
+	}
+	if _, body := poll(t, p, deviceCode); body["error"] != "access_denied" {
+		t.Errorf("poll after Deny = %v, want access_denied", body)
+	}
+	if _, body := poll(t, p, deviceCode); body["error"] != "invalid_grant" {
+		t.Errorf("second poll after Deny = %v, want invalid_grant", body)
+	}
+}
+
+func TestPoll(t *testing.T) {
+	p, advance := newProvider()
+	deviceCode, userCode := authorize(t, p)
+
+	if _, body := poll(t, p, deviceCode); body["error"] != "authorization_pending" {
+		t.Errorf("first poll = %v, want authorization_pending", body)
+	}
+	if _, body := poll(t, p, deviceCode); body["error"] != "slow_down" {
+		t.Errorf("immediate second poll = %v, want slow_down", body)
+	}
+	advance(DefaultInterval)
+	if _, body := poll(t, p, deviceCode); body["error"] != "authorization_pending" {
+		t.Errorf("poll after the interval = %v, want authorization_pending", body)
+	}
+
+	// User codes are matched ignoring case, spaces and hyphens.
+	if err := p.Approve(strings.ToLower(strings.ReplaceAll(userCode, "-", " "))); err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+	if err := p.Approve(userCode); err != ErrUnknownUserCode {
+		t.Errorf("second Approve error = %v, want ErrUnknownUserCode", err)
+	}
+	status, body := poll(t, p, deviceCode)
+	if status != http.StatusOK || body["access_token"] != "access" || body["token_type"] != "Bearer" ||
+		body["scope"] != "openid email" || body["expires_in"] != 3600.0 {
+		t.Errorf("poll after Approve = %v %v", status, body)
+	}
+	if _, body := poll(t, p, deviceCode); body["error"] != "invalid_grant" {
+		t.Errorf("poll after the token = %v, want invalid_grant", body)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	p, advance := newProvider()
+	deviceCode, userCode := authorize(t, p)
+
+	advance(DefaultCodeLifetime)
+	if _, ok := p.Lookup(userCode); ok {
+		t.Error("Lookup found an expired code")
+	}
+	if err := p.Approve(userCode); err != ErrUnknownUserCode {
+		t.Errorf("Approve of an expired code error = %v, want ErrUnknownUserCode", err)
+	}
+	if _, body := poll(t, p, deviceCode); body["error"] != "expired_token" {
+		t.Errorf("poll of an expired code = %v, want expired_token", body)
+	}
+}
+
+func TestIssueTokenError(t *testing.T) {
+	p, advance := newProvider()
+	issue := p.IssueToken
+	fail := true
+	p.IssueToken = func(g Grant) (*Token, error) {
+		if fail {
+			return nil, errors.New("database unavailable")
+		}
+		return issue(g)
+	}
+	deviceCode, userCode := authorize(t, p)
+	p.Approve(userCode)
+
+	if status, body := poll(t, p, deviceCode); status != http.StatusInternalServerError || body["error"] != "server_error" {
+		t.Errorf("poll with IssueToken failing = %v %v, want server_error", status, body)
+	}
+	// The approval survives the failure.
+	fail = false
+	advance(DefaultInterval)
+	if status, body := poll(t, p, deviceCode); status != http.StatusOK || body["access_token"] != "access" {
+		t.Errorf("poll after IssueToken recovers = %v %v, want a token", status, body)
+	}
+}
+
+func TestIssueTokenNil(t *testing.T) {
+	p, _ := newProvider()
+	p.IssueToken = nil
+	deviceCode, userCode := authorize(t, p)
+	p.Approve(userCode)
+
+	if status, body := poll(t, p, deviceCode); status != http.StatusInternalServerError || body["error"] != "server_error" {
+		t.Errorf("poll without IssueToken = %v %v, want server_error", status, body)
+	}
+}
+
+func TestConcurrentPolls(t *testing.T) {
+	p, _ := newProvider()
+	issue := p.IssueToken
+	p.IssueToken = func(g Grant) (*Token, error) {
+		time.Sleep(10 * time.Millisecond)
+		return issue(g)
+	}
+	deviceCode, userCode := authorize(t, p)
+	p.Approve(userCode)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	tokens := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if status, _ := poll(t, p, deviceCode); status == http.StatusOK {
+				mu.Lock()
+				tokens++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if tokens != 1 {
+		t.Errorf("%v tokens issued for one device code, want 1", tokens)
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	p, _ := newProvider()
+	p.ValidClient = func(clientID string, scopes []string) bool { return clientID == "c" }
+	deviceCode, _ := authorize(t, p)
+
+	if status, body := post(t, p.DeviceHandler(), url.Values{"client_id": {"other"}}); status != http.StatusUnauthorized || body["error"] != "invalid_client" {
+		t.Errorf("device request from an invalid client = %v %v", status, body)
+	}
+	if _, body := post(t, p.DeviceHandler(), url.Values{}); body["error"] != "invalid_request" {
+		t.Errorf("device request without client_id = %v", body)
+	}
+	if _, body := post(t, p.TokenHandler(), url.Values{"grant_type": {"password"}}); body["error"] != "unsupported_grant_type" {
+		t.Errorf("token request with another grant = %v", body)
+	}
+	if _, body := post(t, p.TokenHandler(), url.Values{
+		"grant_type":  {deviceGrantType},
+		"device_code": {deviceCode},
+		"client_id":   {"other"},
+	}); body["error"] != "invalid_grant" {
+		t.Errorf("poll by another client = %v", body)
+	}
+
+	for _, h := range []http.Handler{p.DeviceHandler(), p.TokenHandler()} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("GET status = %v, want 405", w.Code)
+		}
+	}
+}
+
+func TestUserCodes(t *testing.T) {
+	p, _ := newProvider()
+	seen := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		_, userCode := authorize(t, p)
+		if len(userCode) != userCodeLength+1 || userCode[userCodeLength/2] != '-' {
+			t.Errorf("user code %q is not of the form XXXX-XXXX", userCode)
+		}
+		for _, r := range strings.ReplaceAll(userCode, "-", "") {
+			if !strings.ContainsRune(userCodeAlphabet, r) {
+				t.Errorf("user code %q contains %q", userCode, r)
+			}
+		}
+		if seen[userCode] {
+			t.Errorf("user code %q issued twice", userCode)
+		}
+		seen[userCode] = true
+	}
+}
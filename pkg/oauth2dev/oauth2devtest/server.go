@@ -0,0 +1,201 @@
+// Package oauth2devtest provides a fake OAuth2 device flow provider for use in
+// tests and examples.
+package oauth2devtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+	"golang.org/x/oauth2"
+)
+
+const (
+	deviceCode = "test-device-code"
+	userCode   = "WDJB-MJHT"
+)
+
+// Options configures the behaviour of a Server. The zero value issues a token
+// on the first poll.
+type Options struct {
+	// SlowDown is the number of polls answered with slow_down.
+	SlowDown int
+
+	// Pending is the number of polls, after any slow_down responses,
+	// answered with authorization_pending.
+	Pending int
+
+	// Error, if set, is the OAuth2 error code, e.g. "access_denied" or
+	// "expired_token", returned once the pending polls are exhausted instead
+	// of a token.
+	Error string
+
+	// Interval and ExpiresIn are returned in the device code response. An
+	// Interval of zero is omitted, so clients fall back to their default.
+	Interval  int64
+	ExpiresIn int64
+
+	// PendingStatus is the HTTP status of slow_down and
+	// authorization_pending responses. If zero, 400 is used as RFC 8628
+	// specifies; some providers use 428.
+	PendingStatus int
+
+	// AccessToken is the access token issued. If empty, "test-access-token"
+	// is used.
+	AccessToken string
+
+	// TokenExtra holds additional fields of the token response, such as
+	// id_token or scope.
+	TokenExtra map[string]interface{}
+}
+
+// A Server is a fake device flow provider listening on a local address.
+type Server struct {
+	*httptest.Server
+	opts Options
+
+	mu         sync.Mutex
+	polls      int
+	deviceForm url.Values
+	tokenForm  url.Values
+}
+
+// NewServer starts and returns a new Server. The caller should call Close when
+// finished to shut it down.
+func NewServer(opts Options) *Server {
+	s := &Server{opts: opts}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device", s.handleDevice)
+	mux.HandleFunc("/token", s.handleToken)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Config returns a Config pointing at s for the given client ID and scopes.
+func (s *Server) Config(clientID string, scopes ...string) *oauth2dev.Config {
+	return &oauth2dev.Config{
+		Config: &oauth2.Config{
+			ClientID: clientID,
+			Endpoint: oauth2.Endpoint{TokenURL: s.URL + "/token"},
+			Scopes:   scopes,
+		},
+		DeviceEndpoint: oauth2dev.DeviceEndpoint{CodeURL: s.URL + "/device"},
+	}
+}
+
+// Polls returns the number of token requests s has received.
+func (s *Server) Polls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.polls
+}
+
+// DeviceRequest returns the parameters of the last device code request s
+// received.
+func (s *Server) DeviceRequest() url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deviceForm
+}
+
+// TokenRequest returns the parameters of the last token request s received.
+func (s *Server) TokenRequest() url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokenForm
+}
+
+func (s *Server) handleDevice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("client_id") == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	s.mu.Lock()
+	s.deviceForm = r.PostForm
+	s.mu.Unlock()
+
+	resp := map[string]interface{}{
+		"device_code":               deviceCode,
+		"user_code":                 userCode,
+		"verification_uri":          s.URL + "/verify",
+		"verification_uri_complete": s.URL + "/verify?user_code=" + userCode,
+	}
+	if s.opts.Interval != 0 {
+		resp["interval"] = s.opts.Interval
+	}
+	if s.opts.ExpiresIn != 0 {
+		resp["expires_in"] = s.opts.ExpiresIn
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "urn:ietf:params:oauth:grant-type:device_code" {
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+	if r.PostForm.Get("device_code") != deviceCode {
+		writeError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	s.mu.Lock()
+	s.polls++
+	n := s.polls
+	s.tokenForm = r.PostForm
+	s.mu.Unlock()
+
+	pending := s.opts.PendingStatus
+	if pending == 0 {
+		pending = http.StatusBadRequest
+	}
+	switch {
+	case n <= s.opts.SlowDown:
+		writeError(w, pending, "slow_down")
+	case n <= s.opts.SlowDown+s.opts.Pending:
+		writeError(w, pending, "authorization_pending")
+	case s.opts.Error != "":
+		writeError(w, http.StatusBadRequest, s.opts.Error)
+	default:
+		tok := s.opts.AccessToken
+		if tok == "" {
+			tok = "test-access-token"
+		}
+		resp := map[string]interface{}{
+			"access_token": tok,
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		}
+		for k, v := range s.opts.TokenExtra {
+			resp[k] = v
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// writeError writes an RFC 6749 error response with the given status and code.
+func writeError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,90 @@
+package oauth2devtest_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev/oauth2devtest"
+)
+
+// authorize runs the device flow against s, polling every millisecond.
+func authorize(t *testing.T, s *oauth2devtest.Server) (*oauth2dev.DeviceCode, error) {
+	t.Helper()
+	config := s.Config("test-client", "openid", "email")
+	config.PollInterval = time.Millisecond
+
+	code, err := oauth2dev.RequestDeviceCode(s.Client(), config)
+	if err != nil {
+		t.Fatalf("RequestDeviceCode: %v", err)
+	}
+	_, err = oauth2dev.WaitForDeviceAuthorization(s.Client(), config, code)
+	return code, err
+}
+
+func TestServer(t *testing.T) {
+	s := oauth2devtest.NewServer(oauth2devtest.Options{SlowDown: 1, Pending: 2, ExpiresIn: 600})
+	defer s.Close()
+
+	code, err := authorize(t, s)
+	if err != nil {
+		t.Fatalf("WaitForDeviceAuthorization: %v", err)
+	}
+	if code.UserCode != "WDJB-MJHT" || code.VerificationURL != s.URL+"/verify" || code.ExpiresIn != 600 {
+		t.Errorf("device code = %+v", code)
+	}
+	if got := s.Polls(); got != 4 {
+		t.Errorf("Polls() = %v, want 4", got)
+	}
+	if got := s.DeviceRequest().Get("scope"); got != "openid email" {
+		t.Errorf("device request scope = %q, want %q", got, "openid email")
+	}
+	if got := s.TokenRequest().Get("client_id"); got != "test-client" {
+		t.Errorf("token request client_id = %q, want %q", got, "test-client")
+	}
+}
+
+func TestServerError(t *testing.T) {
+	s := oauth2devtest.NewServer(oauth2devtest.Options{Pending: 1, Error: "access_denied"})
+	defer s.Close()
+
+	if _, err := authorize(t, s); !errors.Is(err, oauth2dev.ErrAccessDenied) {
+		t.Errorf("WaitForDeviceAuthorization error = %v, want ErrAccessDenied", err)
+	}
+}
+
+func TestServerPendingStatus(t *testing.T) {
+	s := oauth2devtest.NewServer(oauth2devtest.Options{Pending: 1, PendingStatus: http.StatusPreconditionRequired})
+	defer s.Close()
+
+	var statuses []int
+	config := s.Config("test-client")
+	config.PollInterval = time.Millisecond
+	config.OnPoll = func(p oauth2dev.PollInfo) { statuses = append(statuses, p.StatusCode) }
+	if _, err := oauth2dev.Authorize(context.Background(), s.Client(), config, nil); err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if len(statuses) != 2 || statuses[0] != http.StatusPreconditionRequired || statuses[1] != http.StatusOK {
+		t.Errorf("poll statuses = %v, want [428 200]", statuses)
+	}
+}
+
+func TestServerTokenExtra(t *testing.T) {
+	s := oauth2devtest.NewServer(oauth2devtest.Options{
+		AccessToken: "access",
+		TokenExtra:  map[string]interface{}{"scope": "openid"},
+	})
+	defer s.Close()
+
+	config := s.Config("test-client")
+	tok, err := oauth2dev.Authorize(context.Background(), s.Client(), config, nil)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if tok.AccessToken != "access" || tok.Extra("scope") != "openid" {
+		t.Errorf("token = %+v with scope %v", tok, tok.Extra("scope"))
+	}
+}
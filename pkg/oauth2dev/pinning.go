@@ -0,0 +1,47 @@
+package oauth2dev
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrCertificatePinMismatch is an error returned, wrapped, by requests
+	// made with a PinnedHTTPClient to a server whose certificate does not
+	// match the pin.
+	ErrCertificatePinMismatch = errors.New("server certificate does not match pin")
+)
+
+// PinnedHTTPClient returns a DefaultHTTPClient which only connects to servers
+// whose leaf certificate has the given SHA-256 fingerprint, in hex with or
+// without colons, as printed by "openssl x509 -noout -fingerprint -sha256".
+// The certificate must still be valid and trusted as usual; the pin is an
+// additional check. As pinned certificates change when the provider renews
+// them, pinning suits CLIs which can be updated promptly.
+func PinnedHTTPClient(sha256Fingerprint string) (*http.Client, error) {
+	pin, err := hex.DecodeString(strings.ReplaceAll(sha256Fingerprint, ":", ""))
+	if err != nil || len(pin) != sha256.Size {
+		return nil, fmt.Errorf("invalid SHA-256 fingerprint %q", sha256Fingerprint)
+	}
+
+	client := DefaultHTTPClient()
+	client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return ErrCertificatePinMismatch
+			}
+			sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+			if !bytes.Equal(sum[:], pin) {
+				return fmt.Errorf("%w: got %X", ErrCertificatePinMismatch, sum)
+			}
+			return nil
+		},
+	}
+	return client, nil
+}
@@ -0,0 +1,55 @@
+package oauth2dev_test
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+)
+
+func TestPinnedHTTPClient(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	sum := sha256.Sum256(srv.Certificate().Raw)
+
+	// pinned returns a client pinned to fingerprint which trusts srv.
+	pinned := func(fingerprint string) *http.Client {
+		client, err := oauth2dev.PinnedHTTPClient(fingerprint)
+		if err != nil {
+			t.Fatalf("PinnedHTTPClient(%q): %v", fingerprint, err)
+		}
+		client.Transport.(*http.Transport).TLSClientConfig.RootCAs = roots
+		return client
+	}
+
+	colons := strings.TrimSuffix(strings.ReplaceAll(fmt.Sprintf("% X", sum), " ", ":"), ":")
+	for _, fingerprint := range []string{fmt.Sprintf("%x", sum), colons} {
+		resp, err := pinned(fingerprint).Get(srv.URL)
+		if err != nil {
+			t.Errorf("request with pin %q: %v", fingerprint, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+
+	other := sha256.Sum256([]byte("another certificate"))
+	if _, err := pinned(fmt.Sprintf("%x", other)).Get(srv.URL); !errors.Is(err, oauth2dev.ErrCertificatePinMismatch) {
+		t.Errorf("request with the wrong pin error = %v, want ErrCertificatePinMismatch", err)
+	}
+}
+
+func TestPinnedHTTPClientInvalid(t *testing.T) {
+	for _, fingerprint := range []string{"", "not hex", "abcd", strings.Repeat("ab", 33)} {
+		if _, err := oauth2dev.PinnedHTTPClient(fingerprint); err == nil {
+			t.Errorf("PinnedHTTPClient(%q) succeeded", fingerprint)
+		}
+	}
+}
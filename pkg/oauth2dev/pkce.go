@@ -0,0 +1,29 @@
+package oauth2dev
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+const (
+	pkceMethodS256 = "S256"
+)
+
+// GeneratePKCE returns a new random PKCE code verifier together with its S256
+// code challenge. Set the verifier as Config.CodeVerifier to have it used in
+// the device flow. A fresh verifier should be generated for every flow.
+func GeneratePKCE() (verifier, challenge string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	verifier = base64.RawURLEncoding.EncodeToString(b)
+	return verifier, pkceChallenge(verifier), nil
+}
+
+// pkceChallenge returns the S256 code challenge for verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
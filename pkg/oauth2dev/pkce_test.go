@@ -0,0 +1,34 @@
+package oauth2dev_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"regexp"
+	"testing"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+)
+
+func TestGeneratePKCE(t *testing.T) {
+	// RFC 7636 section 4.1: 43 to 128 unreserved characters.
+	unreserved := regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		verifier, challenge, err := oauth2dev.GeneratePKCE()
+		if err != nil {
+			t.Fatalf("GeneratePKCE: %v", err)
+		}
+		if !unreserved.MatchString(verifier) {
+			t.Errorf("verifier %q is not 43 to 128 unreserved characters", verifier)
+		}
+		sum := sha256.Sum256([]byte(verifier))
+		if want := base64.RawURLEncoding.EncodeToString(sum[:]); challenge != want {
+			t.Errorf("challenge of %q = %q, want %q", verifier, challenge, want)
+		}
+		if seen[verifier] {
+			t.Errorf("verifier %q generated twice", verifier)
+		}
+		seen[verifier] = true
+	}
+}
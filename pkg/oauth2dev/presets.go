@@ -0,0 +1,76 @@
+package oauth2dev
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// NewKeycloakClient returns a Client for the device flow of a Keycloak realm.
+// baseURL is the server's root URL, including the /auth prefix for Keycloak
+// versions before 17. Keycloak authenticates confidential clients on the
+// device endpoint as well as the token endpoint, so a non-empty clientSecret
+// is sent to both; leave it empty for a public client.
+func NewKeycloakClient(baseURL, realm, clientID, clientSecret string, scopes []string, opts ...ClientOption) *Client {
+	realmURL := strings.TrimRight(baseURL, "/") + "/realms/" + url.PathEscape(realm) + "/protocol/openid-connect"
+	config := &Config{
+		Config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  realmURL + "/auth",
+				TokenURL: realmURL + "/token",
+			},
+			Scopes: scopes,
+		},
+		DeviceEndpoint: DeviceEndpoint{CodeURL: realmURL + "/auth/device"},
+	}
+	if clientSecret != "" {
+		config.DeviceRequestParams = url.Values{"client_secret": {clientSecret}}
+	}
+	return NewClient(config, opts...)
+}
+
+// NewAzureClient returns a Client for the device flow of the Microsoft
+// identity platform (Azure AD) v2.0 endpoints of a tenant. tenantID may also
+// be "common", "organizations" or "consumers". Its device codes carry a
+// ready-made Message to show the user, which DeviceCode.UserInstructions
+// returns.
+func NewAzureClient(tenantID, clientID string, scopes []string, opts ...ClientOption) *Client {
+	tenantURL := "https://login.microsoftonline.com/" + url.PathEscape(tenantID) + "/oauth2/v2.0"
+	config := &Config{
+		Config: &oauth2.Config{
+			ClientID: clientID,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  tenantURL + "/authorize",
+				TokenURL: tenantURL + "/token",
+			},
+			Scopes: scopes,
+		},
+		DeviceEndpoint: DeviceEndpoint{CodeURL: tenantURL + "/devicecode"},
+	}
+	return NewClient(config, opts...)
+}
+
+// NewGoogleClient returns a Client for Google's device flow, which is only
+// available to OAuth clients of the "TVs and Limited Input devices" type.
+// Google requires the client secret of such clients although they are
+// public. Its device codes use the pre-standard verification_url field, which
+// DeviceCode reads into VerificationURL, and it reports pending authorization
+// with status 428, one of DefaultPendingStatusCodes.
+func NewGoogleClient(clientID, clientSecret string, scopes []string, opts ...ClientOption) *Client {
+	config := &Config{
+		Config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  "https://accounts.google.com/o/oauth2/auth",
+				TokenURL: "https://oauth2.googleapis.com/token",
+			},
+			Scopes: scopes,
+		},
+		DeviceEndpoint: DeviceEndpoint{CodeURL: "https://oauth2.googleapis.com/device/code"},
+	}
+	return NewClient(config, opts...)
+}
@@ -0,0 +1,165 @@
+package oauth2dev_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+)
+
+// A presetProvider stands in for a real provider: its transport sends every
+// request to a local server, recording the URL and form it was meant for.
+type presetProvider struct {
+	srv *httptest.Server
+
+	mu    sync.Mutex
+	urls  []string
+	forms []url.Values
+}
+
+// newPresetProvider starts a presetProvider answering device code requests
+// with deviceBody, and polls first with authorization_pending, with the given
+// status, then with a token.
+func newPresetProvider(t *testing.T, deviceBody string, pendingStatus int) *presetProvider {
+	p := &presetProvider{}
+	polls := 0
+	p.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		p.mu.Lock()
+		p.forms = append(p.forms, r.PostForm)
+		poll := r.PostForm.Get("grant_type") == "urn:ietf:params:oauth:grant-type:device_code"
+		if poll {
+			polls++
+		}
+		first := polls == 1
+		p.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if !poll {
+			w.Write([]byte(deviceBody))
+			return
+		}
+		if first {
+			w.WriteHeader(pendingStatus)
+			w.Write([]byte(`{"error":"authorization_pending"}`))
+			return
+		}
+		w.Write([]byte(`{"access_token":"test-access-token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	t.Cleanup(p.srv.Close)
+	return p
+}
+
+func (p *presetProvider) RoundTrip(r *http.Request) (*http.Response, error) {
+	p.mu.Lock()
+	p.urls = append(p.urls, r.URL.String())
+	p.mu.Unlock()
+
+	target, _ := url.Parse(p.srv.URL)
+	r = r.Clone(r.Context())
+	r.URL.Scheme = target.Scheme
+	r.URL.Host = target.Host
+	r.Host = target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// authorize runs c's device flow on a fake clock, returning the device code.
+func (p *presetProvider) authorize(t *testing.T, c *oauth2dev.Client) *oauth2dev.DeviceCode {
+	t.Helper()
+	oauth2dev.SetClock(c.Config, oauth2dev.NewFakeClock())
+	var code *oauth2dev.DeviceCode
+	tok, err := c.Authorize(context.Background(), func(dc *oauth2dev.DeviceCode) { code = dc })
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if tok.AccessToken != "test-access-token" {
+		t.Errorf("token = %+v", tok)
+	}
+	if len(p.urls) != 3 {
+		t.Fatalf("%d requests, want a device code request and 2 polls: %v", len(p.urls), p.urls)
+	}
+	return code
+}
+
+const presetDeviceBody = `{"device_code":"test-device-code","user_code":"WDJB-MJHT","verification_uri":"https://example.com/verify","expires_in":600,"interval":5}`
+
+func TestNewKeycloakClient(t *testing.T) {
+	p := newPresetProvider(t, presetDeviceBody, http.StatusBadRequest)
+	c := oauth2dev.NewKeycloakClient("https://sso.example.com/auth/", "my realm", "test-client", "test-secret",
+		[]string{"openid"}, oauth2dev.WithTransport(p))
+	p.authorize(t, c)
+
+	const realm = "https://sso.example.com/auth/realms/my%20realm/protocol/openid-connect"
+	if p.urls[0] != realm+"/auth/device" || p.urls[1] != realm+"/token" {
+		t.Errorf("requests = %v", p.urls)
+	}
+	if c.Config.Endpoint.AuthURL != realm+"/auth" {
+		t.Errorf("AuthURL = %q", c.Config.Endpoint.AuthURL)
+	}
+	for i, form := range p.forms {
+		if form.Get("client_id") != "test-client" || form.Get("client_secret") != "test-secret" {
+			t.Errorf("request %d form = %v, want the client credentials", i, form)
+		}
+	}
+	if p.forms[0].Get("scope") != "openid" {
+		t.Errorf("device code request form = %v", p.forms[0])
+	}
+}
+
+func TestNewKeycloakClientPublic(t *testing.T) {
+	p := newPresetProvider(t, presetDeviceBody, http.StatusBadRequest)
+	c := oauth2dev.NewKeycloakClient("https://sso.example.com", "test", "test-client", "", nil, oauth2dev.WithTransport(p))
+	p.authorize(t, c)
+
+	if want := "https://sso.example.com/realms/test/protocol/openid-connect/auth/device"; p.urls[0] != want {
+		t.Errorf("device code request to %s, want %s", p.urls[0], want)
+	}
+	for i, form := range p.forms {
+		if _, ok := form["client_secret"]; ok {
+			t.Errorf("request %d of a public client sent client_secret: %v", i, form)
+		}
+	}
+}
+
+func TestNewAzureClient(t *testing.T) {
+	const message = "To sign in, use a web browser to open the page https://microsoft.com/devicelogin and enter the code WDJB-MJHT to authenticate."
+	p := newPresetProvider(t, `{"device_code":"test-device-code","user_code":"WDJB-MJHT","verification_uri":"https://microsoft.com/devicelogin","expires_in":900,"interval":5,"message":"`+message+`"}`, http.StatusBadRequest)
+	c := oauth2dev.NewAzureClient("organizations", "test-client", []string{"openid", "offline_access"}, oauth2dev.WithTransport(p))
+	code := p.authorize(t, c)
+
+	const tenant = "https://login.microsoftonline.com/organizations/oauth2/v2.0"
+	if p.urls[0] != tenant+"/devicecode" || p.urls[1] != tenant+"/token" {
+		t.Errorf("requests = %v", p.urls)
+	}
+	if c.Config.Endpoint.AuthURL != tenant+"/authorize" {
+		t.Errorf("AuthURL = %q", c.Config.Endpoint.AuthURL)
+	}
+	if p.forms[0].Get("scope") != "openid offline_access" {
+		t.Errorf("device code request form = %v", p.forms[0])
+	}
+	if got := code.UserInstructions(); got != message {
+		t.Errorf("UserInstructions() = %q, want the provider's message", got)
+	}
+}
+
+func TestNewGoogleClient(t *testing.T) {
+	// Google uses verification_url and reports pending authorization with
+	// status 428.
+	p := newPresetProvider(t, `{"device_code":"test-device-code","user_code":"WDJB-MJHT","verification_url":"https://www.google.com/device","expires_in":1800,"interval":5}`, http.StatusPreconditionRequired)
+	c := oauth2dev.NewGoogleClient("test-client", "test-secret", []string{"openid", "email"}, oauth2dev.WithTransport(p))
+	code := p.authorize(t, c)
+
+	if p.urls[0] != "https://oauth2.googleapis.com/device/code" || p.urls[1] != "https://oauth2.googleapis.com/token" {
+		t.Errorf("requests = %v", p.urls)
+	}
+	if code.VerificationURL != "https://www.google.com/device" {
+		t.Errorf("VerificationURL = %q", code.VerificationURL)
+	}
+	if form := p.forms[1]; form.Get("client_secret") != "test-secret" {
+		t.Errorf("poll form = %v, want the client secret", form)
+	}
+}
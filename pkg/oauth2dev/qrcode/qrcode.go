@@ -0,0 +1,84 @@
+// Package qrcode renders device flow verification URLs as QR codes so that
+// users can authorize a headless device by scanning it with their phone. It is
+// kept separate from package oauth2dev so that programs which don't need it
+// don't have to build it or depend on its QR encoder.
+package qrcode
+
+import (
+	"errors"
+	"image/color"
+	"strings"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+	"github.com/boombuler/barcode/qr"
+)
+
+const (
+	// quietZone is the width in modules of the light border around the code,
+	// the minimum ISO/IEC 18004 requires for readers to find the code.
+	quietZone = 4
+
+	ansiReset = "\x1b[0m"
+)
+
+var (
+	// ErrDataTooLong is an error returned when the verification URL does not
+	// fit in the largest QR code.
+	ErrDataTooLong = errors.New("qrcode: data too long")
+)
+
+// ANSI returns a QR code of the device code's VerificationURLComplete, or of
+// VerificationURL if the provider did not return a complete URL. The result
+// uses ANSI colour escapes and half-block characters to draw two rows of
+// modules per line, and can be printed directly to a terminal.
+func ANSI(code *oauth2dev.DeviceCode) (string, error) {
+	u := code.VerificationURLComplete
+	if u == "" {
+		u = code.VerificationURL
+	}
+	if u == "" {
+		return "", errors.New("qrcode: device code has no verification URL")
+	}
+
+	// In byte mode, capacity is the only reason encoding can fail.
+	sym, err := qr.Encode(u, qr.M, qr.Unicode)
+	if err != nil {
+		return "", ErrDataTooLong
+	}
+	size := sym.Bounds().Dx()
+
+	dark := func(x, y int) bool {
+		x, y = x-quietZone, y-quietZone
+		if x < 0 || y < 0 || x >= size || y >= size {
+			return false
+		}
+		return color.GrayModel.Convert(sym.At(x, y)).(color.Gray).Y < 0x80
+	}
+
+	var b strings.Builder
+	width := size + 2*quietZone
+	for y := 0; y < width; y += 2 {
+		for x := 0; x < width; x++ {
+			// The upper half of the cell is drawn in the foreground colour
+			// and the lower half in the background colour.
+			b.WriteString(colour(dark(x, y), dark(x, y+1)))
+			b.WriteString("▀")
+		}
+		b.WriteString(ansiReset)
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+// colour returns the escape sequence selecting a black or white foreground and
+// background.
+func colour(fgDark, bgDark bool) string {
+	fg, bg := "97", "107"
+	if fgDark {
+		fg = "30"
+	}
+	if bgDark {
+		bg = "40"
+	}
+	return "\x1b[" + fg + ";" + bg + "m"
+}
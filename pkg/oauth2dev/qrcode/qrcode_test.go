@@ -0,0 +1,128 @@
+package qrcode_test
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev/qrcode"
+	"github.com/makiuchi-d/gozxing"
+	gozxingqr "github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// parse returns the modules drawn by ANSI, including the quiet zone; true is
+// dark.
+func parse(t *testing.T, s string) [][]bool {
+	t.Helper()
+	var rows [][]bool
+	for _, line := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+		line = strings.TrimSuffix(line, "\x1b[0m")
+		var upper, lower []bool
+		for _, cell := range strings.Split(strings.TrimSuffix(line, "▀"), "▀") {
+			var fg, bg int
+			if _, err := fmt.Sscanf(cell, "\x1b[%d;%dm", &fg, &bg); err != nil {
+				t.Fatalf("malformed cell %q: %v", cell, err)
+			}
+			upper = append(upper, fg == 30)
+			lower = append(lower, bg == 40)
+		}
+		rows = append(rows, upper, lower)
+	}
+	return rows
+}
+
+// decode reads the QR code in modules with an independent decoder.
+func decode(t *testing.T, modules [][]bool) string {
+	t.Helper()
+	const scale = 4
+	img := image.NewGray(image.Rect(0, 0, len(modules[0])*scale, len(modules)*scale))
+	for y := range modules {
+		for x, dark := range modules[y] {
+			c := color.Gray{Y: 0xff}
+			if dark {
+				c.Y = 0
+			}
+			for i := 0; i < scale*scale; i++ {
+				img.SetGray(x*scale+i%scale, y*scale+i/scale, c)
+			}
+		}
+	}
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := gozxingqr.NewQRCodeReader().Decode(bmp, nil)
+	if err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	return result.GetText()
+}
+
+func TestANSI(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		minVersion int
+	}{
+		{"short", "https://example.com/device?user_code=WDJB-MJHT", 1},
+		// From version 7 symbols carry version information blocks.
+		{"version 7+", "https://login.example.com/oauth2/v2.0/device?user_code=WDJB-MJHT&tenant=" + strings.Repeat("t", 100), 7},
+		{"version 10+", "https://example.com/device?state=" + strings.Repeat("s", 300), 10},
+		{"non-ASCII", "https://例え.jp/device?user_code=WDJB-MJHT", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := qrcode.ANSI(&oauth2dev.DeviceCode{VerificationURL: "https://example.com", VerificationURLComplete: tt.url})
+			if err != nil {
+				t.Fatalf("ANSI: %v", err)
+			}
+			modules := parse(t, s)
+			width := len(modules[0])
+
+			// A version v symbol is 17+4v modules wide, plus the quiet zone.
+			size := width - 8
+			if v := (size - 17) / 4; size%4 != 1 || v < tt.minVersion {
+				t.Errorf("symbol is %v modules wide, want version %v or later", size, tt.minVersion)
+			}
+			// Odd widths leave a light half row at the bottom.
+			if len(modules) != width+width%2 {
+				t.Errorf("%v rows of %v modules", len(modules), width)
+			}
+			for y, row := range modules {
+				for x, dark := range row {
+					if dark && (x < 4 || y < 4 || x >= width-4 || y >= width-4) {
+						t.Fatalf("dark module at (%v, %v), in the quiet zone", x, y)
+					}
+				}
+			}
+
+			if got := decode(t, modules); got != tt.url {
+				t.Errorf("decoded %q, want %q", got, tt.url)
+			}
+		})
+	}
+}
+
+func TestANSIVerificationURL(t *testing.T) {
+	s, err := qrcode.ANSI(&oauth2dev.DeviceCode{VerificationURL: "https://example.com/device"})
+	if err != nil {
+		t.Fatalf("ANSI: %v", err)
+	}
+	if got := decode(t, parse(t, s)); got != "https://example.com/device" {
+		t.Errorf("decoded %q, want the verification URL", got)
+	}
+}
+
+func TestANSIErrors(t *testing.T) {
+	if _, err := qrcode.ANSI(&oauth2dev.DeviceCode{}); err == nil {
+		t.Error("ANSI without a URL succeeded")
+	}
+	long := &oauth2dev.DeviceCode{VerificationURL: "https://example.com/" + strings.Repeat("a", 3000)}
+	if _, err := qrcode.ANSI(long); !errors.Is(err, qrcode.ErrDataTooLong) {
+		t.Errorf("ANSI of a long URL error = %v, want ErrDataTooLong", err)
+	}
+}
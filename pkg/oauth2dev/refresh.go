@@ -0,0 +1,87 @@
+package oauth2dev
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Refresh exchanges refreshToken, as returned with a token from the device
+// flow, for a new token. This lets programs which persist the refresh token
+// avoid running the device flow again. If the provider does not issue a new
+// refresh token, the returned token carries refreshToken over. If the provider
+// refuses the refresh, the error is an *AuthorizationError. If client is nil,
+// http.DefaultClient is used.
+func (c *Config) Refresh(ctx context.Context, client *http.Client, refreshToken string) (*oauth2.Token, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	client = (&Client{HTTPClient: client}).httpClient()
+
+	req, err := c.newTokenRequest(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken}})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(client, req)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody(resp)
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest &&
+		resp.StatusCode != http.StatusUnauthorized {
+		return nil, fmt.Errorf("%w when refreshing OAuth token", newHTTPError(resp))
+	}
+
+	body, err := readJSON(resp, c.maxResponseSize())
+	if err != nil {
+		return nil, err
+	}
+	var token tokenOrError
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, err
+	}
+	if token.Error != "" {
+		return nil, &AuthorizationError{
+			Code:        token.Error,
+			Description: string(token.ErrorDescription),
+			URI:         token.ErrorURI,
+		}
+	}
+
+	tok, err := token.oauth2Token(body, c.clock().Now())
+	if err != nil {
+		return nil, err
+	}
+	if tok.RefreshToken == "" {
+		tok.RefreshToken = refreshToken
+	}
+	return tok, nil
+}
+
+// oauth2Token returns the token from a successful token response, with its
+// expiry computed relative to now. raw is the whole response, whose fields are
+// made available via Token.Extra.
+func (t *tokenOrError) oauth2Token(raw json.RawMessage, now time.Time) (*oauth2.Token, error) {
+	if t.Token == nil || t.AccessToken == "" {
+		return nil, errors.New("token response has no access_token")
+	}
+	if t.Expiry.IsZero() && t.ExpiresIn != 0 {
+		t.Expiry = now.Add(time.Duration(t.ExpiresIn) * time.Second)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	return withResponse(t.Token, fields), nil
+}
@@ -0,0 +1,74 @@
+package oauth2dev_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+)
+
+func TestRefresh(t *testing.T) {
+	s := newTestServer(t, response{http.StatusOK,
+		`{"access_token":"new-access","token_type":"Bearer","expires_in":60,"refresh_token":"new-refresh"}`, nil})
+	s.config.ClientSecret = "test-secret"
+
+	tok, err := s.config.Refresh(context.Background(), s.Client(), "old-refresh")
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if tok.AccessToken != "new-access" || tok.RefreshToken != "new-refresh" {
+		t.Errorf("token = %+v", tok)
+	}
+	if want := s.clock.Now().Add(time.Minute); !tok.Expiry.Equal(want) {
+		t.Errorf("Expiry = %v, want %v", tok.Expiry, want)
+	}
+
+	form := s.poll(0).PostForm
+	if form.Get("grant_type") != "refresh_token" || form.Get("refresh_token") != "old-refresh" ||
+		form.Get("client_id") != "test-client" || form.Get("client_secret") != "test-secret" {
+		t.Errorf("refresh request = %v", form)
+	}
+}
+
+func TestRefreshKeepsRefreshToken(t *testing.T) {
+	s := newTestServer(t, token)
+
+	tok, err := s.config.Refresh(context.Background(), s.Client(), "old-refresh")
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if tok.RefreshToken != "old-refresh" {
+		t.Errorf("RefreshToken = %q, want %q", tok.RefreshToken, "old-refresh")
+	}
+}
+
+func TestRefreshNilClient(t *testing.T) {
+	s := newTestServer(t, token)
+
+	if _, err := s.config.Refresh(context.Background(), nil, "old-refresh"); err != nil {
+		t.Errorf("Refresh with a nil client: %v", err)
+	}
+}
+
+func TestRefreshErrors(t *testing.T) {
+	s := newTestServer(t, response{http.StatusBadRequest, `{"error":"invalid_grant","error_description":"Token revoked"}`, nil})
+	_, err := s.config.Refresh(context.Background(), s.Client(), "old-refresh")
+	var authErr *oauth2dev.AuthorizationError
+	if !errors.As(err, &authErr) || authErr.Code != "invalid_grant" || authErr.Description != "Token revoked" {
+		t.Errorf("Refresh error = %v, want an invalid_grant *AuthorizationError", err)
+	}
+
+	s = newTestServer(t, response{http.StatusBadGateway, `oops`, nil})
+	_, err = s.config.Refresh(context.Background(), s.Client(), "old-refresh")
+	var httpErr *oauth2dev.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("Refresh error = %v, want a 502 *HTTPError", err)
+	}
+
+	if _, err := (&oauth2dev.Config{}).Refresh(context.Background(), nil, "old-refresh"); err == nil {
+		t.Error("Refresh with an invalid Config succeeded")
+	}
+}
@@ -0,0 +1,31 @@
+package oauth2dev
+
+import (
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// CompareScopes reads the scopes granted to token from the scope field of the
+// token response and returns them along with those of requested which were not
+// granted, so that a caller can warn when the provider narrowed the request.
+// As RFC 6749 section 5.1 allows, a token response without a scope field is
+// taken to grant exactly the requested scopes.
+func CompareScopes(requested []string, token *oauth2.Token) (granted, missing []string) {
+	scope, ok := token.Extra("scope").(string)
+	if !ok {
+		return append([]string(nil), requested...), nil
+	}
+
+	granted = strings.Fields(scope)
+	have := make(map[string]bool, len(granted))
+	for _, s := range granted {
+		have[s] = true
+	}
+	for _, s := range requested {
+		if !have[s] {
+			missing = append(missing, s)
+		}
+	}
+	return granted, missing
+}
@@ -0,0 +1,57 @@
+package oauth2dev_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+	"golang.org/x/oauth2"
+)
+
+func TestCompareScopes(t *testing.T) {
+	requested := []string{"openid", "email", "offline_access"}
+	tests := []struct {
+		name             string
+		extra            map[string]interface{}
+		granted, missing []string
+	}{{
+		name:    "no scope field",
+		granted: requested,
+	}, {
+		name:    "all granted",
+		extra:   map[string]interface{}{"scope": "email openid offline_access"},
+		granted: []string{"email", "openid", "offline_access"},
+	}, {
+		name:    "narrowed",
+		extra:   map[string]interface{}{"scope": "openid  profile"},
+		granted: []string{"openid", "profile"},
+		missing: []string{"email", "offline_access"},
+	}, {
+		name:    "empty",
+		extra:   map[string]interface{}{"scope": ""},
+		granted: []string{},
+		missing: requested,
+	}, {
+		name:    "not a string",
+		extra:   map[string]interface{}{"scope": []interface{}{"openid"}},
+		granted: requested,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tok := &oauth2.Token{AccessToken: "access"}
+			if tt.extra != nil {
+				tok = tok.WithExtra(tt.extra)
+			}
+			granted, missing := oauth2dev.CompareScopes(requested, tok)
+			if !reflect.DeepEqual(granted, tt.granted) || !reflect.DeepEqual(missing, tt.missing) {
+				t.Errorf("CompareScopes = %q, %q, want %q, %q", granted, missing, tt.granted, tt.missing)
+			}
+		})
+	}
+
+	granted, _ := oauth2dev.CompareScopes(requested, &oauth2.Token{})
+	granted[0] = "changed"
+	if requested[0] != "openid" {
+		t.Error("CompareScopes returned requested itself")
+	}
+}
@@ -0,0 +1,23 @@
+package oauth2dev
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// SignalContext returns a context which is cancelled when the process
+// receives SIGINT, as sent by Ctrl-C, or SIGTERM, so that a CLI waiting for
+// the user to authorize it stops cleanly rather than hanging. The returned
+// function cancels the context and stops relaying signals; call it when done.
+// After a first signal has cancelled the context, further signals are handled
+// as usual, so a second Ctrl-C exits immediately.
+func SignalContext() (context.Context, func()) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
@@ -0,0 +1,77 @@
+package oauth2dev_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+)
+
+// interrupt sends SIGINT to the test process.
+func interrupt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("cannot send signals on Windows")
+	}
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignalContext(t *testing.T) {
+	ctx, stop := oauth2dev.SignalContext()
+	defer stop()
+
+	interrupt(t)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not cancelled by SIGINT")
+	}
+	if ctx.Err() != context.Canceled {
+		t.Errorf("Err = %v, want context.Canceled", ctx.Err())
+	}
+}
+
+func TestSignalContextStop(t *testing.T) {
+	ctx, stop := oauth2dev.SignalContext()
+	stop()
+	if ctx.Err() != context.Canceled {
+		t.Errorf("Err after stop = %v, want context.Canceled", ctx.Err())
+	}
+	stop()
+}
+
+func TestSignalContextSecondSignal(t *testing.T) {
+	if os.Getenv("OAUTH2DEV_TEST_SIGNAL_CHILD") == "1" {
+		ctx, stop := oauth2dev.SignalContext()
+		defer stop()
+		interrupt(t)
+		<-ctx.Done()
+		// Once relaying has stopped, an interrupt kills the process.
+		for i := 0; i < 500; i++ {
+			interrupt(t)
+			time.Sleep(10 * time.Millisecond)
+		}
+		return
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("cannot send signals on Windows")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSignalContextSecondSignal$")
+	cmd.Env = append(os.Environ(), "OAUTH2DEV_TEST_SIGNAL_CHILD=1")
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != -1 {
+		t.Errorf("process survived a second SIGINT: %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package oauth2dev
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/oauth2"
+)
+
+var (
+	// ErrTokenNotFound is an error returned by LoadToken when there is no
+	// saved token, in which case the caller should run the device flow.
+	ErrTokenNotFound = errors.New("no saved token")
+)
+
+// A savedToken is the form in which SaveToken writes a token.
+type savedToken struct {
+	*oauth2.Token
+	Extra map[string]interface{} `json:"extra,omitempty"`
+}
+
+// SaveToken writes tok to the file at path as JSON, readable only by the
+// current user. Besides the fields of oauth2.Token it keeps the fields of the
+// token response available via Token.Extra once loaded: all of them for tokens
+// returned by this package, or else id_token, scope, issued_token_type and
+// ext_expires_in. The file is replaced atomically, so a concurrent LoadToken
+// never sees a partly written token.
+func SaveToken(path string, tok *oauth2.Token) error {
+	data, err := json.Marshal(savedToken{Token: tok, Extra: tokenExtra(tok)})
+	if err != nil {
+		return err
+	}
+
+	// os.CreateTemp creates the file with mode 0600.
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// LoadToken reads a token written by SaveToken. If there is no file at path
+// the error wraps ErrTokenNotFound. The token may have expired; callers
+// should check Token.Valid or use it with Config.Refresh.
+func LoadToken(path string) (*oauth2.Token, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("loading token from %s: %w", path, ErrTokenNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var saved savedToken
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("loading token from %s: %w", path, err)
+	}
+	if saved.Token == nil {
+		return nil, fmt.Errorf("loading token from %s: no token in file", path)
+	}
+	if saved.Extra != nil {
+		return withResponse(saved.Token, saved.Extra), nil
+	}
+	return saved.Token, nil
+}
+
+// responseKey is the key under which tokens made by this package hold all the
+// fields of their token response, so that SaveToken can keep them; Token.Extra
+// only gives access to them one at a time.
+const responseKey = "oauth2dev.response"
+
+// extraFields are the fields of the token response which SaveToken keeps for
+// tokens not made by this package, such as those from oauth2.Config.Exchange.
+var extraFields = []string{
+	"id_token",
+	"scope",
+	"issued_token_type",
+	"ext_expires_in",
+}
+
+// withResponse returns tok with fields, the fields of the token response it
+// was made from, available via Token.Extra, both one at a time and together
+// under responseKey.
+func withResponse(tok *oauth2.Token, fields map[string]interface{}) *oauth2.Token {
+	extra := make(map[string]interface{}, len(fields)+1)
+	for k, v := range fields {
+		extra[k] = v
+	}
+	extra[responseKey] = fields
+	return tok.WithExtra(extra)
+}
+
+// tokenExtra returns the fields of the token response held by tok: all of them
+// if tok was made by this package, otherwise only those in extraFields which
+// are set.
+func tokenExtra(tok *oauth2.Token) map[string]interface{} {
+	if fields, ok := tok.Extra(responseKey).(map[string]interface{}); ok {
+		return fields
+	}
+	var extra map[string]interface{}
+	for _, k := range extraFields {
+		// Token.Extra returns "" for fields missing from a form-encoded
+		// response.
+		if v := tok.Extra(k); v != nil && v != "" {
+			if extra == nil {
+				extra = make(map[string]interface{})
+			}
+			extra[k] = v
+		}
+	}
+	return extra
+}
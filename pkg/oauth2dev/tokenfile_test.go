@@ -0,0 +1,126 @@
+package oauth2dev_test
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+	"golang.org/x/oauth2"
+)
+
+func TestSaveToken(t *testing.T) {
+	s := newTestServer(t, response{http.StatusOK, `{"access_token":"access","token_type":"Bearer","expires_in":3600,` +
+		`"refresh_token":"refresh","id_token":"id","scope":"openid email","ext_expires_in":7200,` +
+		`"tenant":{"id":"t1","regions":["eu","us"]},"admin":false}`, nil})
+	tok, err := s.wait()
+	if err != nil {
+		t.Fatalf("WaitForDeviceAuthorization: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "token.json")
+	if err := oauth2dev.SaveToken(path, tok); err != nil {
+		t.Fatalf("SaveToken: %v", err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("token file mode = %v, %v, want 0600", fi.Mode(), err)
+	}
+	loaded, err := oauth2dev.LoadToken(path)
+	if err != nil {
+		t.Fatalf("LoadToken: %v", err)
+	}
+
+	if loaded.AccessToken != "access" || loaded.RefreshToken != "refresh" || loaded.TokenType != "Bearer" ||
+		!loaded.Expiry.Equal(tok.Expiry) {
+		t.Errorf("loaded token = %+v, want %+v", loaded, tok)
+	}
+	for _, k := range []string{"id_token", "scope", "ext_expires_in", "tenant", "admin", "expires_in"} {
+		if got, want := loaded.Extra(k), tok.Extra(k); !reflect.DeepEqual(got, want) {
+			t.Errorf("loaded Extra(%q) = %#v, want %#v", k, got, want)
+		}
+	}
+
+	// A loaded token keeps all its fields when saved again.
+	if err := oauth2dev.SaveToken(path, loaded); err != nil {
+		t.Fatalf("SaveToken of a loaded token: %v", err)
+	}
+	if reloaded, err := oauth2dev.LoadToken(path); err != nil || !reflect.DeepEqual(reloaded.Extra("tenant"), tok.Extra("tenant")) {
+		t.Errorf("reloaded Extra(\"tenant\") = %#v, %v", reloaded.Extra("tenant"), err)
+	}
+}
+
+func TestSaveTokenFormExtra(t *testing.T) {
+	tok := (&oauth2.Token{AccessToken: "access"}).WithExtra(url.Values{"scope": {"openid"}, "empty": {}})
+	path := filepath.Join(t.TempDir(), "token.json")
+	if err := oauth2dev.SaveToken(path, tok); err != nil {
+		t.Fatalf("SaveToken: %v", err)
+	}
+	loaded, err := oauth2dev.LoadToken(path)
+	if err != nil {
+		t.Fatalf("LoadToken: %v", err)
+	}
+	if got := loaded.Extra("scope"); got != "openid" {
+		t.Errorf("loaded Extra(\"scope\") = %#v, want %q", got, "openid")
+	}
+}
+
+func TestSaveTokenOtherExtra(t *testing.T) {
+	// Of a token not made by this package, only the documented fields are
+	// kept.
+	tok := (&oauth2.Token{AccessToken: "access"}).WithExtra(map[string]interface{}{"id_token": "id", "tenant": "t1"})
+	path := filepath.Join(t.TempDir(), "token.json")
+	if err := oauth2dev.SaveToken(path, tok); err != nil {
+		t.Fatalf("SaveToken: %v", err)
+	}
+	loaded, err := oauth2dev.LoadToken(path)
+	if err != nil {
+		t.Fatalf("LoadToken: %v", err)
+	}
+	if got := loaded.Extra("id_token"); got != "id" {
+		t.Errorf("loaded Extra(\"id_token\") = %#v, want %q", got, "id")
+	}
+	if got := loaded.Extra("tenant"); got != nil {
+		t.Errorf("loaded Extra(\"tenant\") = %#v, want nil", got)
+	}
+}
+
+func TestSaveTokenWithoutExtra(t *testing.T) {
+	tok := &oauth2.Token{AccessToken: "access", Expiry: time.Now().Add(time.Hour).Round(0)}
+	path := filepath.Join(t.TempDir(), "token.json")
+	if err := oauth2dev.SaveToken(path, tok); err != nil {
+		t.Fatalf("SaveToken: %v", err)
+	}
+	// An existing file is replaced.
+	if err := oauth2dev.SaveToken(path, tok); err != nil {
+		t.Fatalf("SaveToken over an existing file: %v", err)
+	}
+	loaded, err := oauth2dev.LoadToken(path)
+	if err != nil || loaded.AccessToken != "access" || !loaded.Expiry.Equal(tok.Expiry) {
+		t.Errorf("LoadToken = %+v, %v, want %+v", loaded, err, tok)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("directory holds %v files, want only the token", len(entries))
+	}
+}
+
+func TestLoadTokenErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := oauth2dev.LoadToken(filepath.Join(dir, "missing.json")); !errors.Is(err, oauth2dev.ErrTokenNotFound) {
+		t.Errorf("LoadToken of a missing file error = %v, want ErrTokenNotFound", err)
+	}
+
+	for name, data := range map[string]string{"garbage.json": "not json", "empty.json": "{}"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := oauth2dev.LoadToken(path); err == nil || errors.Is(err, oauth2dev.ErrTokenNotFound) {
+			t.Errorf("LoadToken of %q error = %v", data, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package oauth2dev
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// A TokenResponse is a token response with the fields commonly sent besides
+// those of oauth2.Token parsed from its extras.
+type TokenResponse struct {
+	*oauth2.Token
+
+	// IDToken is the raw OIDC id_token, if any.
+	IDToken string
+	// Scope is the space-separated list of scopes granted, if the provider
+	// sent it; see CompareScopes.
+	Scope string
+	// ExpiresIn is the lifetime of the access token as sent, zero if unknown.
+	ExpiresIn time.Duration
+	// RefreshTokenExpiresIn is the lifetime of the refresh token, sent by
+	// some providers such as Azure AD, zero if unknown.
+	RefreshTokenExpiresIn time.Duration
+	// IssuedTokenType is the RFC 8693 issued_token_type, if any.
+	IssuedTokenType string
+}
+
+// ParseTokenResponse returns the TokenResponse for token, which should have
+// been returned by this package so that its extras hold the whole response.
+func ParseTokenResponse(token *oauth2.Token) *TokenResponse {
+	r := &TokenResponse{
+		Token:                 token,
+		ExpiresIn:             extraSeconds(token, "expires_in"),
+		RefreshTokenExpiresIn: extraSeconds(token, "refresh_token_expires_in"),
+	}
+	r.IDToken, _ = token.Extra("id_token").(string)
+	r.Scope, _ = token.Extra("scope").(string)
+	r.IssuedTokenType, _ = token.Extra("issued_token_type").(string)
+	return r
+}
+
+// extraSeconds returns the duration in seconds in the extra field key of
+// token, which may be a JSON number or string.
+func extraSeconds(token *oauth2.Token, key string) time.Duration {
+	switch v := token.Extra(key).(type) {
+	case float64:
+		return time.Duration(v) * time.Second
+	case string:
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return 0
+}
+
+// WaitForDeviceAuthorizationResponse is like WaitForDeviceAuthorizationContext
+// but returns the parsed TokenResponse.
+func WaitForDeviceAuthorizationResponse(ctx context.Context, client *http.Client, config *Config, code *DeviceCode) (*TokenResponse, error) {
+	tok, err := WaitForDeviceAuthorizationContext(ctx, client, config, code)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTokenResponse(tok), nil
+}
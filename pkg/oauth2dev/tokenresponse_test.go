@@ -0,0 +1,60 @@
+package oauth2dev_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev/oauth2devtest"
+	"golang.org/x/oauth2"
+)
+
+func TestParseTokenResponse(t *testing.T) {
+	tok := (&oauth2.Token{AccessToken: "access"}).WithExtra(map[string]interface{}{
+		"id_token":                 "id",
+		"scope":                    "openid email",
+		"expires_in":               3600.0,
+		"refresh_token_expires_in": "86400",
+		"issued_token_type":        "urn:ietf:params:oauth:token-type:access_token",
+	})
+	want := &oauth2dev.TokenResponse{
+		Token:                 tok,
+		IDToken:               "id",
+		Scope:                 "openid email",
+		ExpiresIn:             time.Hour,
+		RefreshTokenExpiresIn: 24 * time.Hour,
+		IssuedTokenType:       "urn:ietf:params:oauth:token-type:access_token",
+	}
+	if got := oauth2dev.ParseTokenResponse(tok); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseTokenResponse = %+v, want %+v", got, want)
+	}
+
+	tok = (&oauth2.Token{AccessToken: "access"}).WithExtra(map[string]interface{}{"expires_in": "soon", "scope": 1.0})
+	if got := oauth2dev.ParseTokenResponse(tok); got.ExpiresIn != 0 || got.Scope != "" {
+		t.Errorf("ParseTokenResponse of malformed fields = %+v", got)
+	}
+	if got := oauth2dev.ParseTokenResponse(&oauth2.Token{}); got.IDToken != "" || got.ExpiresIn != 0 {
+		t.Errorf("ParseTokenResponse without extras = %+v", got)
+	}
+}
+
+func TestWaitForDeviceAuthorizationResponse(t *testing.T) {
+	s, config, _ := newFixture(t, oauth2devtest.Options{
+		TokenExtra: map[string]interface{}{"id_token": "id", "ext_expires_in": 7200},
+	})
+
+	resp, err := oauth2dev.WaitForDeviceAuthorizationResponse(context.Background(), s.Client(), config, testCode())
+	if err != nil {
+		t.Fatalf("WaitForDeviceAuthorizationResponse: %v", err)
+	}
+	if resp.AccessToken != "test-access-token" || resp.IDToken != "id" || resp.ExpiresIn != time.Hour {
+		t.Errorf("response = %+v", resp)
+	}
+
+	s, config, _ = newFixture(t, oauth2devtest.Options{Error: "access_denied"})
+	if _, err := oauth2dev.WaitForDeviceAuthorizationResponse(context.Background(), s.Client(), config, testCode()); err == nil {
+		t.Error("WaitForDeviceAuthorizationResponse succeeded when access was denied")
+	}
+}
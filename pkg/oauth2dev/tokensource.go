@@ -0,0 +1,97 @@
+package oauth2dev
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// deviceTokenSource is an oauth2.TokenSource which obtains tokens by running
+// the device flow, refreshing them when possible.
+type deviceTokenSource struct {
+	ctx    context.Context
+	client *http.Client
+	config *Config
+	prompt func(*DeviceCode)
+	store  TokenStore
+
+	mu     sync.Mutex
+	tok    *oauth2.Token
+	loaded bool
+}
+
+// DeviceTokenSource returns an oauth2.TokenSource which runs the device flow
+// the first time a token is needed. prompt is called with the device code so
+// that the caller can show the verification URL and user code to the user.
+// Once a token expires it is refreshed using its refresh token if it has one,
+// falling back to running the device flow again. The returned TokenSource may
+// be used with oauth2.NewClient.
+func (c *Config) DeviceTokenSource(ctx context.Context, client *http.Client, prompt func(*DeviceCode)) oauth2.TokenSource {
+	return &deviceTokenSource{
+		ctx:    ctx,
+		client: client,
+		config: c,
+		prompt: prompt,
+	}
+}
+
+// DeviceTokenSourceWithStore is like DeviceTokenSource but keeps its token in
+// store. The first time a token is needed it is loaded from store, and every
+// token obtained, by refreshing or by running the device flow, is saved there.
+// Errors saving a token are passed to c.OnError but do not stop the token
+// being returned.
+func (c *Config) DeviceTokenSourceWithStore(ctx context.Context, client *http.Client, store TokenStore, prompt func(*DeviceCode)) oauth2.TokenSource {
+	return &deviceTokenSource{
+		ctx:    ctx,
+		client: client,
+		config: c,
+		prompt: prompt,
+		store:  store,
+	}
+}
+
+// Token returns a valid token, running the device flow if necessary.
+func (s *deviceTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.store != nil && !s.loaded {
+		s.loaded = true
+		// A token which cannot be loaded is simply obtained afresh.
+		if tok, err := s.store.Load(); err == nil {
+			s.tok = tok
+		}
+	}
+
+	if s.tok.Valid() {
+		return s.tok, nil
+	}
+
+	if s.tok != nil && s.tok.RefreshToken != "" {
+		tok, err := s.config.Refresh(s.ctx, s.client, s.tok.RefreshToken)
+		if err == nil {
+			s.save(tok)
+			return tok, nil
+		}
+	}
+
+	tok, err := Authorize(s.ctx, s.client, s.config, s.prompt)
+	if err != nil {
+		return nil, err
+	}
+	s.save(tok)
+	return tok, nil
+}
+
+// save records tok as the current token, saving it to s.store if set.
+func (s *deviceTokenSource) save(tok *oauth2.Token) {
+	s.tok = tok
+	if s.store != nil {
+		if err := s.store.Save(tok); err != nil {
+			s.config.onError(fmt.Errorf("saving token: %w", err))
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package oauth2dev_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev/oauth2devtest"
+	"golang.org/x/oauth2"
+)
+
+// expiring is a token response whose token is already within
+// oauth2.Token.Valid's expiry margin.
+var expiring = response{http.StatusOK,
+	`{"access_token":"first","token_type":"Bearer","expires_in":1,"refresh_token":"test-refresh"}`, nil}
+
+func TestDeviceTokenSource(t *testing.T) {
+	s, config, _ := newFixture(t, oauth2devtest.Options{Pending: 1})
+	var prompted []*oauth2dev.DeviceCode
+	ts := config.DeviceTokenSource(context.Background(), s.Client(), func(code *oauth2dev.DeviceCode) {
+		prompted = append(prompted, code)
+	})
+
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if len(prompted) != 1 || prompted[0].UserCode != "WDJB-MJHT" {
+		t.Errorf("prompted with %v", prompted)
+	}
+	again, err := ts.Token()
+	if err != nil || again != tok {
+		t.Errorf("second Token = %v, %v, want the first token", again, err)
+	}
+	if len(prompted) != 1 || s.Polls() != 2 {
+		t.Errorf("valid token not reused: %v prompts, %v polls", len(prompted), s.Polls())
+	}
+}
+
+func TestDeviceTokenSourceRefresh(t *testing.T) {
+	s := newTestServer(t, expiring, token)
+	// A nil client is used for refreshing as well as for the device flow.
+	ts := s.config.DeviceTokenSource(context.Background(), nil, nil)
+
+	if _, err := ts.Token(); err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatalf("Token after expiry: %v", err)
+	}
+	if tok.AccessToken != "test-access-token" || tok.RefreshToken != "test-refresh" {
+		t.Errorf("refreshed token = %+v", tok)
+	}
+	if got := s.poll(1).PostForm.Get("grant_type"); got != "refresh_token" {
+		t.Errorf("second request grant_type = %q, want refresh_token", got)
+	}
+	if len(s.device) != 1 {
+		t.Errorf("device flow run %v times, want once", len(s.device))
+	}
+}
+
+func TestDeviceTokenSourceRefreshFails(t *testing.T) {
+	s := newTestServer(t, expiring, response{http.StatusBadRequest, `{"error":"invalid_grant"}`, nil}, token)
+	prompts := 0
+	ts := s.config.DeviceTokenSource(context.Background(), s.Client(), func(*oauth2dev.DeviceCode) { prompts++ })
+
+	ts.Token()
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatalf("Token after a failed refresh: %v", err)
+	}
+	if tok.AccessToken != "test-access-token" || prompts != 2 {
+		t.Errorf("token = %+v after %v prompts, want a new device flow", tok, prompts)
+	}
+}
+
+// failingStore is a TokenStore which cannot save.
+type failingStore struct {
+	oauth2dev.MemoryTokenStore
+}
+
+var errStoreFull = errors.New("store full")
+
+func (s *failingStore) Save(tok *oauth2.Token) error {
+	return errStoreFull
+}
+
+func TestDeviceTokenSourceWithStore(t *testing.T) {
+	s := newTestServer(t, token)
+	store := &oauth2dev.MemoryTokenStore{}
+	ts := s.config.DeviceTokenSourceWithStore(context.Background(), s.Client(), store, nil)
+
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if saved, err := store.Load(); err != nil || saved != tok {
+		t.Errorf("saved token = %v, %v, want %v", saved, err, tok)
+	}
+
+	// A new source uses the saved token without running the device flow.
+	ts = s.config.DeviceTokenSourceWithStore(context.Background(), s.Client(), store, nil)
+	if loaded, err := ts.Token(); err != nil || loaded != tok {
+		t.Errorf("Token from the store = %v, %v, want %v", loaded, err, tok)
+	}
+	if len(s.device) != 1 {
+		t.Errorf("device flow run %v times, want once", len(s.device))
+	}
+
+	var errs []error
+	s.config.OnError = func(err error) { errs = append(errs, err) }
+	ts = s.config.DeviceTokenSourceWithStore(context.Background(), s.Client(), &failingStore{}, nil)
+	if _, err := ts.Token(); err != nil {
+		t.Errorf("Token with a failing store: %v", err)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], errStoreFull) {
+		t.Errorf("OnError called with %v, want %v", errs, errStoreFull)
+	}
+
+	// A saved token which has expired is refreshed rather than replaced.
+	s = newTestServer(t, token)
+	store = &oauth2dev.MemoryTokenStore{}
+	store.Save(&oauth2.Token{AccessToken: "old", RefreshToken: "test-refresh", Expiry: s.clock.Now().Add(-time.Hour)})
+	ts = s.config.DeviceTokenSourceWithStore(context.Background(), s.Client(), store, nil)
+	if tok, err := ts.Token(); err != nil || tok.AccessToken != "test-access-token" {
+		t.Errorf("Token with an expired saved token = %v, %v", tok, err)
+	}
+	if got := s.poll(0).PostForm.Get("grant_type"); got != "refresh_token" || len(s.device) != 0 {
+		t.Errorf("expired saved token not refreshed: grant_type %q, %v device requests", got, len(s.device))
+	}
+	if saved, _ := store.Load(); saved.AccessToken != "test-access-token" {
+		t.Errorf("refreshed token not saved: %+v", saved)
+	}
+}
@@ -0,0 +1,59 @@
+package oauth2dev
+
+import (
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// A TokenStore persists the token of a DeviceTokenSourceWithStore, for
+// example on disk or in the system keyring.
+type TokenStore interface {
+	// Load returns the saved token. If there is none, the error should
+	// wrap ErrTokenNotFound.
+	Load() (*oauth2.Token, error)
+	// Save replaces the saved token with tok.
+	Save(tok *oauth2.Token) error
+}
+
+// A FileTokenStore is a TokenStore keeping its token in the file at Path, as
+// written by SaveToken.
+type FileTokenStore struct {
+	Path string
+}
+
+// Load reads the token with LoadToken.
+func (s FileTokenStore) Load() (*oauth2.Token, error) {
+	return LoadToken(s.Path)
+}
+
+// Save writes tok with SaveToken.
+func (s FileTokenStore) Save(tok *oauth2.Token) error {
+	return SaveToken(s.Path, tok)
+}
+
+// A MemoryTokenStore is a TokenStore keeping its token in memory, so that it
+// lasts only as long as the process. The zero value is an empty store ready
+// to use, and it is safe for concurrent use.
+type MemoryTokenStore struct {
+	mu  sync.Mutex
+	tok *oauth2.Token
+}
+
+// Load returns the saved token.
+func (s *MemoryTokenStore) Load() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tok == nil {
+		return nil, ErrTokenNotFound
+	}
+	return s.tok, nil
+}
+
+// Save replaces the saved token with tok.
+func (s *MemoryTokenStore) Save(tok *oauth2.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tok = tok
+	return nil
+}
@@ -0,0 +1,48 @@
+package oauth2dev_test
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+	"golang.org/x/oauth2"
+)
+
+func testTokenStore(t *testing.T, store oauth2dev.TokenStore) {
+	if _, err := store.Load(); !errors.Is(err, oauth2dev.ErrTokenNotFound) {
+		t.Errorf("Load of an empty store error = %v, want ErrTokenNotFound", err)
+	}
+	for _, access := range []string{"first", "second"} {
+		if err := store.Save(&oauth2.Token{AccessToken: access, RefreshToken: "refresh"}); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		tok, err := store.Load()
+		if err != nil || tok.AccessToken != access || tok.RefreshToken != "refresh" {
+			t.Errorf("Load = %+v, %v, want the %v token", tok, err, access)
+		}
+	}
+}
+
+func TestFileTokenStore(t *testing.T) {
+	testTokenStore(t, oauth2dev.FileTokenStore{Path: filepath.Join(t.TempDir(), "token.json")})
+}
+
+func TestMemoryTokenStore(t *testing.T) {
+	testTokenStore(t, &oauth2dev.MemoryTokenStore{})
+}
+
+func TestMemoryTokenStoreConcurrent(t *testing.T) {
+	var store oauth2dev.MemoryTokenStore
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Save(&oauth2.Token{AccessToken: "access"})
+			store.Load()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,30 @@
+package oauth2dev
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// A TokenTypeError is returned by CheckTokenType when a token is not of the
+// expected type, for example a DPoP-bound token where a Bearer token was
+// expected.
+type TokenTypeError struct {
+	Want, Got string
+}
+
+func (e *TokenTypeError) Error() string {
+	return fmt.Sprintf("token has type %q, expected %q", e.Got, e.Want)
+}
+
+// CheckTokenType returns a *TokenTypeError unless token's token_type is want.
+// Token types are compared case-insensitively, as required by RFC 6749
+// section 7.1, and a missing token_type is taken to be Bearer, as it is by
+// (*oauth2.Token).Type.
+func CheckTokenType(token *oauth2.Token, want string) error {
+	if got := token.Type(); !strings.EqualFold(got, want) {
+		return &TokenTypeError{Want: want, Got: got}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package oauth2dev_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ahanafy/oidcgo/pkg/oauth2dev"
+	"golang.org/x/oauth2"
+)
+
+func TestCheckTokenType(t *testing.T) {
+	tests := []struct {
+		tokenType, want string
+		ok              bool
+	}{
+		{"Bearer", "Bearer", true},
+		{"bearer", "Bearer", true},
+		{"", "Bearer", true},
+		{"DPoP", "dpop", true},
+		{"DPoP", "Bearer", false},
+		{"", "DPoP", false},
+	}
+	for _, tt := range tests {
+		err := oauth2dev.CheckTokenType(&oauth2.Token{AccessToken: "a", TokenType: tt.tokenType}, tt.want)
+		if tt.ok && err != nil {
+			t.Errorf("CheckTokenType(%q, %q) = %v, want nil", tt.tokenType, tt.want, err)
+			continue
+		}
+		if tt.ok {
+			continue
+		}
+		var typeErr *oauth2dev.TokenTypeError
+		if !errors.As(err, &typeErr) || typeErr.Want != tt.want {
+			t.Errorf("CheckTokenType(%q, %q) = %v, want a *TokenTypeError", tt.tokenType, tt.want, err)
+		}
+	}
+
+	err := &oauth2dev.TokenTypeError{Want: "Bearer", Got: "DPoP"}
+	if got, want := err.Error(), `token has type "DPoP", expected "Bearer"`; got != want {
+		t.Errorf("Error = %q, want %q", got, want)
+	}
+}